@@ -15,8 +15,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -29,14 +33,17 @@ import (
 
 var cabCmd = &Command{
 	Name:    "cabinet",
-	Usage:   "<repo> [<id>]",
+	Usage:   "<repo> [<id> [<id2>]]",
 	Summary: "Save, list, or restore dependency snapshots.",
 	Help: `The cabinet command saves dependency information for the given
-repository in a file within it.  The <repo> can be any piece of the
-repository root path, as long as it is unique.  The <tag> is anything
-understood by the underlying version control system as a commit, usually a
-tag, branch, or commit.  When saving, the <id> will override the default
-(based on the date) and when opening the <id> is the ID (or a unique
+repository in a file within it.  A cabinet records the revision of every
+repository that the given repository depends upon, directly or indirectly
+(including the dependencies of its tests), so that opening it reproduces the
+whole set; the dependencies are pinned in dependency order.  The <repo> can
+be any piece of the repository root path, as long as it is unique.  The <tag>
+is anything understood by the underlying version control system as a commit,
+usually a tag, branch, or commit.  When saving, the <id> will override the
+default (based on the date) and when opening the <id> is the ID (or a unique
 substring) of the cabinet to open.
 
 Cabinets are currently stored in the repository itself named as follows:
@@ -52,7 +59,33 @@ before creation fails, the cabinet will not be created.  If the test after
 restoration fails, the repositories will be reverted to their original
 revisions.
 
-The cabinet format is still in flux.`,
+When opening a cabinet, repositories with uncommitted changes are not
+touched unless --force or --stash is specified; see "rx help prescribe".
+
+With --dry-run, --open only reports what it would do: for each pinned
+repository found locally, its current and target revisions, whether it has
+uncommitted changes, and whether the target revision is present.  Nothing is
+fetched, moved, or tested, and repositories which have not been scanned are
+reported as needing to be downloaded.
+
+Each repository is returned to the branch (or Mercurial bookmark) it was on
+when the cabinet was created, as long as the branch still points to the same
+revision.  Otherwise, the repository is left detached at that revision unless
+--reset-branch is specified, in which case the branch is moved back to it.
+
+Cabinets are written as indented JSON with a format Version, so that they can
+be reviewed and diffed along with the rest of the repository.  Cabinets written
+by older versions of rx in a binary (gob) format can still be opened, and
+--convert rewrites those matching <id> (or all of them) as JSON.
+
+The --diff option compares the dependencies pinned by cabinet <id> with those
+pinned by cabinet <id2> or, if only <id> is given, with the revisions currently
+checked out, which shows what opening the cabinet would change.  Repositories
+pinned on only one side are listed as added (+) or removed (-), and those pinned
+at different revisions or branches as changed (~).  For a changed repository
+which is present locally, the number of commits added and removed between the
+old and new revisions is shown, along with the tags that come into or drop out
+of its history.  Revisions which have not been fetched cannot be compared.`,
 }
 
 const cabIDFormat = "20060102-150405"
@@ -63,12 +96,23 @@ var (
 	cabBuild = cabCmd.Flag.Bool("build", false, "create a new cabinet")
 	cabOpen  = cabCmd.Flag.Bool("open", false, "open the specified cabinet")
 	cabDump  = cabCmd.Flag.Bool("dump", false, "list the contents of the specified cabinet")
+	cabConv  = cabCmd.Flag.Bool("convert", false, "rewrite old binary cabinets as JSON")
+	cabDiff  = cabCmd.Flag.Bool("diff", false, "compare the specified cabinet with the working copies or with <id2>")
+	cabDry   = cabCmd.Flag.Bool("dry-run", false, "with --open, report what would change without changing anything")
+	cabReset = cabCmd.Flag.Bool("reset-branch", false, "move recorded branches back to the pinned revision if they have moved on")
+	cabDirty = newDirtyPolicy(&cabCmd.Flag)
 )
 
 func cabFunc(cmd *Command, args ...string) {
-	if len(args) < 1 || len(args) > 2 {
+	if len(args) < 1 || len(args) > 3 {
 		cmd.BadArgs("requires two arguments")
 	}
+	if len(args) > 2 && !*cabDiff {
+		cmd.BadArgs("only --diff takes a second <id>")
+	}
+	if *cabDry && !*cabOpen {
+		cmd.BadArgs("--dry-run requires --open")
+	}
 	path := args[0]
 
 	repo, err := Deps.FindRepo(path)
@@ -94,6 +138,17 @@ func cabFunc(cmd *Command, args ...string) {
 			cmd.BadArgs("must specify <id> to dump")
 		}
 		err = dumpCabinet(repo, id)
+	case *cabConv:
+		err = convertCabinets(repo, id)
+	case *cabDiff:
+		if id == "" {
+			cmd.BadArgs("must specify <id> to diff")
+		}
+		var id2 string
+		if len(args) > 2 {
+			id2 = args[2]
+		}
+		err = diffCabinets(repo, id, id2)
 	case *cabList:
 		err = listCabinets(repo, id)
 	default:
@@ -104,12 +159,18 @@ func cabFunc(cmd *Command, args ...string) {
 	}
 }
 
+// cabFormatVersion is the version of the cabinet format written by
+// writeCabinet.  Cabinets without a version are version 0, and were written
+// with encoding/gob.
+const cabFormatVersion = 1
+
 // A CabFile is the data structure stored in a cabinet file.
 type CabFile struct {
+	Version int            // The cabinet format version
 	Repo    string         // The import path prefix covered by this cabinet (usually import/path/...)
 	Created time.Time      // The time the cabinet was created
 	Head    string         // The hash of the repository at which this cabinet was created
-	Deps    []*RepoVersion // The dependencies of this package
+	Deps    []*RepoVersion // The transitive dependencies of this package, in dependency order
 }
 
 // A RepoVersion stores information about a dependency of a repository.
@@ -117,6 +178,7 @@ type RepoVersion struct {
 	Pattern  string   // The pattern required to scan for updates
 	Packages []string // Try `go get -d` on these in order until one succeeds
 	Head     string   // The hash of the repository to use after installation
+	Branch   string   // The branch (or bookmark) at Head, if any
 }
 
 // NewRepoVersion creates a repo version object suitable for storing into cabinets, etc.
@@ -125,15 +187,39 @@ func NewRepoVersion(repo *graph.Repository) (*RepoVersion, error) {
 	if err != nil {
 		return nil, fmt.Errorf("get %s head: %s", repo, err)
 	}
+	branch, err := repo.Branch()
+	if err != nil {
+		return nil, fmt.Errorf("get %s branch: %s", repo, err)
+	}
 	return &RepoVersion{
 		Pattern:  repo.String(),
 		Packages: repo.Packages,
 		Head:     head,
+		Branch:   branch,
 	}, nil
 }
 
-// Apply attempts to locate the repository and pin it to the head version.
-func (dep *RepoVersion) Apply() error {
+// localRepo returns the scanned repository containing one of the packages
+// recorded in dep, or nil if there is none.  Unlike Apply, it does not fetch or
+// scan anything.
+func (dep *RepoVersion) localRepo() *graph.Repository {
+	for _, pkg := range dep.Packages {
+		p, ok := Deps.Package[pkg]
+		if !ok {
+			continue
+		}
+		if repo, ok := Deps.Repository[p.RepoRoot]; ok {
+			return repo
+		}
+	}
+	return nil
+}
+
+// Apply attempts to locate the repository and pin it to the head version,
+// checking out the recorded branch if it still points there (or, if
+// resetBranch is true, moving it back there).  Uncommitted changes in the
+// repository are handled according to policy.
+func (dep *RepoVersion) Apply(policy *dirtyPolicy, resetBranch bool) (err error) {
 	// Find the repository
 	var repo *graph.Repository
 	for _, pkg := range dep.Packages {
@@ -147,7 +233,7 @@ func (dep *RepoVersion) Apply() error {
 		}
 
 		// Scan for new packages
-		if err := Deps.Scan(dep.Pattern); err != nil {
+		if err := Rescan(dep.Pattern); err != nil {
 			continue
 		}
 
@@ -175,17 +261,42 @@ func (dep *RepoVersion) Apply() error {
 	if err != nil {
 		return fmt.Errorf("apply(%q@%q): unable to determine fallback version", dep.Pattern, dep.Head)
 	}
+	fallbackBranch, err := repo.Branch()
+	if err != nil {
+		return fmt.Errorf("apply(%q@%q): unable to determine fallback branch", dep.Pattern, dep.Head)
+	}
+
+	// Check for local changes before touching anything
+	restore, err := policy.prepare(repo)
+	if err != nil {
+		return fmt.Errorf("apply(%q@%q): %s", dep.Pattern, dep.Head, err)
+	}
+	defer func() {
+		if uerr := restore(); uerr != nil && err == nil {
+			err = fmt.Errorf("apply(%q@%q): %s", dep.Pattern, dep.Head, uerr)
+		}
+	}()
 
 	// Pin the version
-	if err := repo.ToRev(dep.Head); err != nil {
-		if ferr := repo.ToRev(fallback); ferr != nil {
+	err = repo.ToBranch(dep.Branch, dep.Head, resetBranch)
+	if moved, ok := err.(*graph.BranchMovedError); ok {
+		log.Printf("Pinned %s @ %s, but branch %q has moved on to %s (use --reset-branch to move it back)",
+			dep.Pattern, dep.Head, moved.Branch, moved.Now)
+		return nil
+	}
+	if err != nil {
+		if ferr := repo.ToBranch(fallbackBranch, fallback, false); ferr != nil {
 			return fmt.Errorf("apply(%q): pin(%q) [%s] and fallback(%q) [%s] failed",
 				dep.Pattern, dep.Head, err, fallback, ferr)
 		}
 		return fmt.Errorf("apply(%q@%q): pin failed: %s", dep.Pattern, dep.Head, err)
 	}
 
-	log.Printf("Pinned %s @ %s", dep.Pattern, dep.Head)
+	if dep.Branch != "" {
+		log.Printf("Pinned %s @ %s (%s)", dep.Pattern, dep.Head, dep.Branch)
+	} else {
+		log.Printf("Pinned %s @ %s", dep.Pattern, dep.Head)
+	}
 	return nil
 }
 
@@ -200,7 +311,7 @@ func buildCabinet(repo *graph.Repository, id string) error {
 		Created: time.Now(),
 		Head:    head,
 	}
-	deps, err := Deps.RepoDeps(repo)
+	deps, err := cabinetDeps(repo)
 	if err != nil {
 		return fmt.Errorf("build: scan dependencies: %s", err)
 	}
@@ -238,7 +349,7 @@ func buildCabinet(repo *graph.Repository, id string) error {
 	}
 	defer file.Close()
 
-	if err := gob.NewEncoder(file).Encode(data); err != nil {
+	if err := writeCabinet(file, data); err != nil {
 		return fmt.Errorf("build: encoding cabinet: %s", err)
 	}
 
@@ -246,6 +357,32 @@ func buildCabinet(repo *graph.Repository, id string) error {
 	return nil
 }
 
+// cabinetDeps returns every repository needed to build and test repo, other
+// than repo itself, in dependency order.  Since the cabinet is tested, this
+// includes the dependencies of its tests, along with everything that each
+// dependency needs to build.
+func cabinetDeps(repo *graph.Repository) ([]*graph.Repository, error) {
+	direct, err := Deps.RepoTestDeps(repo)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[*graph.Repository]bool{repo: true}
+	var all []*graph.Repository
+	for _, dep := range direct {
+		indirect, err := Deps.TransitiveRepoDeps(dep, graph.ImportEdges, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range append([]*graph.Repository{dep}, indirect...) {
+			if !seen[r] {
+				seen[r] = true
+				all = append(all, r)
+			}
+		}
+	}
+	return Deps.SortRepos(all)
+}
+
 func listCabinetFiles(repo *graph.Repository, filter string) ([]string, error) {
 	pattern := filepath.Join(repo.Root, ".rx", "cabinet-*")
 
@@ -285,16 +422,114 @@ func loadCabinet(repo *graph.Repository, id string) (string, *CabFile, error) {
 	}
 	filename := files[0]
 
+	data, err := readCabinetFile(filename)
+	if err != nil {
+		return filename, nil, err
+	}
+	return filename, data, nil
+}
+
+// readCabinetFile reads and decodes the named cabinet file.
+func readCabinetFile(filename string) (*CabFile, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return filename, nil, fmt.Errorf("open cabinet: %s", err)
+		return nil, fmt.Errorf("open cabinet: %s", err)
+	}
+	defer file.Close()
+
+	data, err := readCabinet(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode cabinet %q: %s", filename, err)
+	}
+	return data, nil
+}
+
+// writeCabinet writes the cabinet as indented JSON with the current format
+// version.  The dependencies are written in the order given, which should be
+// the (deterministic) dependency order from cabinetDeps so that the output
+// only changes when the pinned versions do.
+func writeCabinet(w io.Writer, data *CabFile) error {
+	data.Version = cabFormatVersion
+
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", js)
+	return err
+}
+
+// readCabinet decodes a cabinet written by writeCabinet, or one written by an
+// older version of rx with encoding/gob.
+func readCabinet(r io.Reader) (*CabFile, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
 	data := new(CabFile)
-	if err := gob.NewDecoder(file).Decode(data); err != nil {
-		return filename, nil, fmt.Errorf("decode cabinet: %s", err)
+	if !isJSONCabinet(raw) {
+		if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(data); err != nil {
+			return nil, err
+		}
+		data.Version = 0
+		return data, nil
 	}
-	return filename, data, nil
+
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+	if data.Version < 1 || data.Version > cabFormatVersion {
+		return nil, fmt.Errorf("unsupported cabinet format version %d (want 1 to %d)", data.Version, cabFormatVersion)
+	}
+	return data, nil
+}
+
+// isJSONCabinet returns true if the raw cabinet data is JSON rather than gob.
+func isJSONCabinet(raw []byte) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && raw[0] == '{'
+}
+
+// convertCabinets rewrites the repository's gob cabinets matching id as JSON.
+// Each file is replaced atomically, so a failure leaves the original intact.
+func convertCabinets(repo *graph.Repository, id string) error {
+	files, err := listCabinetFiles(repo, id)
+	if err != nil {
+		return fmt.Errorf("convert: list: %s", err)
+	}
+
+	for _, filename := range files {
+		data, err := readCabinetFile(filename)
+		if err != nil {
+			return fmt.Errorf("convert: %s", err)
+		}
+		if data.Version != 0 {
+			log.Printf("Cabinet %q is already JSON", filename)
+			continue
+		}
+
+		tmp, err := ioutil.TempFile(filepath.Dir(filename), ".convert-")
+		if err != nil {
+			return fmt.Errorf("convert: %s", err)
+		}
+		err = writeCabinet(tmp, data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filename)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("convert %q: %s", filename, err)
+		}
+		fmt.Fprintf(stdout, "Converted %s\n", filename)
+	}
+	return nil
 }
 
 func openCabinet(cmd *Command, repo *graph.Repository, id string) error {
@@ -303,9 +538,19 @@ func openCabinet(cmd *Command, repo *graph.Repository, id string) error {
 		return fmt.Errorf("open: %s", err)
 	}
 
+	if *cabDry {
+		var plans []*pinPlan
+		for _, dep := range data.Deps {
+			plans = append(plans, planPin(dep))
+		}
+		return printPlans(stdout, plans, cabDirty)
+	}
+
+	// The dependencies were recorded in dependency order, so each one is
+	// pinned before the repositories which depend on it.
 	var errors int
 	for _, dep := range data.Deps {
-		if err := dep.Apply(); err != nil {
+		if err := dep.Apply(cabDirty, *cabReset); err != nil {
 			errors++
 			cmd.Errorf("open: %s", err)
 		}
@@ -340,11 +585,172 @@ func dumpCabinet(repo *graph.Repository, id string) error {
 	return nil
 }
 
+// A CabDiff lists the differences between two sets of pinned repositories.
+type CabDiff struct {
+	From, To string         // Descriptions of the old and new sets
+	Added    []*RepoVersion // Repositories pinned only in the new set
+	Removed  []*RepoVersion // Repositories pinned only in the old set
+	Changed  []*CabChange   // Repositories pinned differently in each set
+}
+
+// A CabChange describes a repository pinned at a different revision or branch.
+type CabChange struct {
+	From, To *RepoVersion
+	Ahead    int      // Commits in the new revision which are not in the old
+	Behind   int      // Commits in the old revision which are not in the new
+	Gained   []string // Tags in the new revision which are not in the old
+	Lost     []string // Tags in the old revision which are not in the new
+	Err      error    // Why the revisions could not be compared, if they couldn't
+}
+
+// diffVersions matches up the repository versions in from and to by pattern.
+// Added and changed versions are listed in the order of to, and removed ones in
+// the order of from.  Nothing is compared beyond the recorded fields; see
+// CabChange.compare.
+func diffVersions(from, to []*RepoVersion) *CabDiff {
+	diff := new(CabDiff)
+	old := map[string]*RepoVersion{}
+	for _, rv := range from {
+		old[rv.Pattern] = rv
+	}
+	pinned := map[string]bool{}
+	for _, rv := range to {
+		pinned[rv.Pattern] = true
+		prev, ok := old[rv.Pattern]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, rv)
+		case prev.Head != rv.Head || prev.Branch != rv.Branch:
+			diff.Changed = append(diff.Changed, &CabChange{From: prev, To: rv})
+		}
+	}
+	for _, rv := range from {
+		if !pinned[rv.Pattern] {
+			diff.Removed = append(diff.Removed, rv)
+		}
+	}
+	return diff
+}
+
+// compare counts the commits and tags between the old and new revisions,
+// using the local copy of the repository.  On failure, c.Err is set.
+func (c *CabChange) compare() {
+	if c.From.Head == c.To.Head {
+		return
+	}
+	repo := c.To.localRepo()
+	if repo == nil {
+		repo = c.From.localRepo()
+	}
+	if repo == nil {
+		c.Err = fmt.Errorf("repository not found locally")
+		return
+	}
+
+	var err error
+	if c.Ahead, err = repo.Count(c.From.Head, c.To.Head); err != nil {
+		c.Err = err
+		return
+	}
+	if c.Behind, err = repo.Count(c.To.Head, c.From.Head); err != nil {
+		c.Err = err
+		return
+	}
+	for _, between := range []struct {
+		from, to string
+		names    *[]string
+	}{
+		{c.From.Head, c.To.Head, &c.Gained},
+		{c.To.Head, c.From.Head, &c.Lost},
+	} {
+		tags, err := repo.TagsBetween(between.from, between.to)
+		if err != nil {
+			c.Err = err
+			return
+		}
+		for _, tag := range tags {
+			*between.names = append(*between.names, tag.Name)
+		}
+	}
+}
+
+// diffCabinets shows how the dependencies pinned by cabinet id differ from
+// those pinned by cabinet id2 or, if id2 is empty, how the revisions currently
+// checked out differ from cabinet id (that is, what opening it would change).
+func diffCabinets(repo *graph.Repository, id, id2 string) error {
+	filename, data, err := loadCabinet(repo, id)
+	if err != nil {
+		return fmt.Errorf("diff: %s", err)
+	}
+
+	var diff *CabDiff
+	if id2 != "" {
+		filename2, data2, err := loadCabinet(repo, id2)
+		if err != nil {
+			return fmt.Errorf("diff: %s", err)
+		}
+		diff = diffVersions(data.Deps, data2.Deps)
+		diff.From, diff.To = filename, filename2
+	} else {
+		working, err := workingVersions(repo, data.Deps)
+		if err != nil {
+			return fmt.Errorf("diff: %s", err)
+		}
+		diff = diffVersions(working, data.Deps)
+		diff.From, diff.To = "working copies", filename
+	}
+
+	for _, change := range diff.Changed {
+		change.compare()
+	}
+	render(stdout, cabDiffTemplate, diff)
+	return nil
+}
+
+// workingVersions returns the current versions of the repositories that a
+// cabinet for repo would record, followed by those of any other repositories
+// pinned in deps which are present locally.
+func workingVersions(repo *graph.Repository, deps []*RepoVersion) ([]*RepoVersion, error) {
+	repos, err := cabinetDeps(repo)
+	if err != nil {
+		return nil, fmt.Errorf("scan dependencies: %s", err)
+	}
+	seen := map[*graph.Repository]bool{}
+	for _, r := range repos {
+		seen[r] = true
+	}
+	for _, dep := range deps {
+		if r := dep.localRepo(); r != nil && !seen[r] {
+			seen[r] = true
+			repos = append(repos, r)
+		}
+	}
+
+	var working []*RepoVersion
+	for _, r := range repos {
+		rv, err := NewRepoVersion(r)
+		if err != nil {
+			return nil, err
+		}
+		working = append(working, rv)
+	}
+	return working, nil
+}
+
 var (
 	cabDumpTemplate = `Repository:    {{.Repo}}
 Created:       {{.Created}} @ {{.Head}}
 Dependencies:{{range .Deps}}
-  {{.Head}} {{.Pattern}}{{end}}
+  {{.Head}} {{.Pattern}}{{with .Branch}} ({{.}}){{end}}{{end}}
+`
+	cabDiffTemplate = `--- {{.From}}
++++ {{.To}}{{range .Removed}}
+- {{.Pattern}} {{.Head}}{{with .Branch}} ({{.}}){{end}}{{end}}{{range .Added}}
++ {{.Pattern}} {{.Head}}{{with .Branch}} ({{.}}){{end}}{{end}}{{range .Changed}}
+~ {{.To.Pattern}} {{.From.Head}}{{with .From.Branch}} ({{.}}){{end}} -> {{.To.Head}}{{with .To.Branch}} ({{.}}){{end}}{{if .Err}}
+    unable to compare: {{.Err}}{{else if ne .From.Head .To.Head}}
+    {{.Ahead}} commits added, {{.Behind}} removed{{with .Gained}}; tags added: {{join . ", "}}{{end}}{{with .Lost}}; tags removed: {{join . ", "}}{{end}}{{end}}{{end}}{{if not (or .Added .Removed .Changed)}}
+no differences{{end}}
 `
 )
 
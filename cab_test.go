@@ -0,0 +1,233 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestCabinetFormat(t *testing.T) {
+	data := &CabFile{
+		Repo:    "example.com/app",
+		Created: time.Date(2013, 3, 13, 1, 2, 3, 0, time.UTC),
+		Head:    "abc",
+		Deps: []*RepoVersion{
+			{Pattern: "example.com/web/...", Packages: []string{"example.com/web"}, Head: "def", Branch: "master"},
+			{Pattern: "example.com/base", Packages: []string{"example.com/base"}, Head: "123"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeCabinet(buf, data); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	js := buf.String()
+	if !strings.HasPrefix(js, "{\n\t\"Version\": 1,\n") {
+		t.Errorf("cabinet does not start with its version:\n%s", js)
+	}
+	if strings.Index(js, "example.com/web/...") > strings.Index(js, "example.com/base") {
+		t.Errorf("dependencies were reordered:\n%s", js)
+	}
+
+	read, err := readCabinet(buf)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if !reflect.DeepEqual(read, data) {
+		t.Errorf("read = %+v, want %+v", read, data)
+	}
+
+	// Cabinets written by older versions of rx are gobs without a version
+	buf.Reset()
+	gob.NewEncoder(buf).Encode(data)
+	if read, err := readCabinet(buf); err != nil || read.Version != 0 || read.Head != "abc" {
+		t.Errorf("read gob = %+v, %v; want version 0 cabinet", read, err)
+	}
+
+	if _, err := readCabinet(strings.NewReader(`{"Version": 99}`)); err == nil {
+		t.Errorf("reading a newer cabinet version succeeded")
+	}
+}
+
+func TestCabinetDeps(t *testing.T) {
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = testGraph(map[string][]string{
+		"app":   {"web", "app"},
+		"web":   {"util", "log"},
+		"util":  {"base"},
+		"log":   {"base"},
+		"base":  nil,
+		"other": {"app"},
+	})
+
+	deps, err := cabinetDeps(Deps.Repository["app"])
+	if err != nil {
+		t.Fatalf("deps: %s", err)
+	}
+	var got []string
+	for _, dep := range deps {
+		got = append(got, dep.Root)
+	}
+	if want := []string{"base", "log", "util", "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deps = %q, want %q", got, want)
+	}
+}
+
+func TestConvertCabinets(t *testing.T) {
+	defer func(old io.Writer) { stdout = old }(stdout)
+	stdout = ioutil.Discard
+
+	root, err := ioutil.TempDir("", "rx-cab-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(root)
+	repo := &graph.Repository{Root: root}
+
+	dir := filepath.Join(root, ".rx")
+	os.MkdirAll(dir, 0755)
+	data := &CabFile{Repo: "example.com/app", Head: "abc"}
+	old := filepath.Join(dir, "cabinet-old")
+	file, _ := os.Create(old)
+	gob.NewEncoder(file).Encode(data)
+	file.Close()
+
+	if err := convertCabinets(repo, ""); err != nil {
+		t.Fatalf("convert: %s", err)
+	}
+	raw, err := ioutil.ReadFile(old)
+	if err != nil {
+		t.Fatalf("read converted: %s", err)
+	}
+	if !isJSONCabinet(raw) {
+		t.Errorf("cabinet was not converted:\n%s", raw)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("convert left %d files, want 1", len(files))
+	}
+
+	// Converting again leaves the file alone
+	if err := convertCabinets(repo, "old"); err != nil {
+		t.Fatalf("convert again: %s", err)
+	}
+	if again, _ := ioutil.ReadFile(old); !bytes.Equal(again, raw) {
+		t.Errorf("converted cabinet was rewritten")
+	}
+}
+
+func TestCabinetDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+	root, err := ioutil.TempDir("", "rx-cabdiff-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	gitRun(t, root, "init", "-q")
+	var revs []string
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		gitCommit(t, root, "lib.go", "package lib // "+tag+"\n")
+		gitRun(t, root, "tag", tag)
+		revs = append(revs, gitRun(t, root, "rev-parse", "HEAD"))
+	}
+	gitRun(t, root, "checkout", "-q", "v2")
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	Deps.Repository[root] = &graph.Repository{Root: root, VCS: "git", Packages: []string{"example.com/lib"}}
+	Deps.Package["example.com/lib"] = &graph.Package{ImportPath: "example.com/lib", RepoRoot: root}
+
+	lib := func(rev, branch string) *RepoVersion {
+		return &RepoVersion{Pattern: "example.com/lib", Packages: []string{"example.com/lib"}, Head: rev, Branch: branch}
+	}
+	gone := &RepoVersion{Pattern: "example.com/gone", Head: "aaa"}
+	added := &RepoVersion{Pattern: "example.com/added", Head: "bbb"}
+	same := &RepoVersion{Pattern: "example.com/same", Head: "ccc"}
+
+	diff := diffVersions(
+		[]*RepoVersion{same, gone, lib(revs[0], "")},
+		[]*RepoVersion{lib(revs[2], "master"), added, same},
+	)
+	if len(diff.Added) != 1 || diff.Added[0] != added {
+		t.Errorf("added = %v, want [%v]", diff.Added, added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != gone {
+		t.Errorf("removed = %v, want [%v]", diff.Removed, gone)
+	}
+	if len(diff.Changed) != 1 {
+		t.Fatalf("changed = %v, want one change", diff.Changed)
+	}
+
+	up := diff.Changed[0]
+	up.compare()
+	if up.Err != nil {
+		t.Fatalf("compare: %s", up.Err)
+	}
+	if got, want := []int{up.Ahead, up.Behind}, []int{2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("upgrade ahead, behind = %v, want %v", got, want)
+	}
+	if want := []string{"v3", "v2"}; !reflect.DeepEqual(up.Gained, want) || len(up.Lost) > 0 {
+		t.Errorf("upgrade tags = +%q -%q, want +%q", up.Gained, up.Lost, want)
+	}
+
+	down := &CabChange{From: lib(revs[2], ""), To: lib(revs[1], "")}
+	down.compare()
+	if down.Err != nil {
+		t.Fatalf("compare: %s", down.Err)
+	}
+	if down.Ahead != 0 || down.Behind != 1 || len(down.Gained) > 0 || !reflect.DeepEqual(down.Lost, []string{"v3"}) {
+		t.Errorf("downgrade = %d, %d, +%q -%q; want 0, 1, -[v3]", down.Ahead, down.Behind, down.Gained, down.Lost)
+	}
+
+	missing := &CabChange{From: lib(revs[0], ""), To: lib("0123456789abcdef0123456789abcdef01234567", "")}
+	if missing.compare(); missing.Err == nil {
+		t.Errorf("comparing with an unknown revision succeeded")
+	}
+
+	diff.From, diff.To = "old", "new"
+	buf := new(bytes.Buffer)
+	render(buf, cabDiffTemplate, diff)
+	for _, want := range []string{
+		"--- old\n+++ new\n",
+		"\n- example.com/gone aaa\n",
+		"\n+ example.com/added bbb\n",
+		"\n~ example.com/lib " + revs[0] + " -> " + revs[2] + " (master)\n",
+		"\n    2 commits added, 0 removed; tags added: v3, v2\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("diff output does not contain %q:\n%s", want, buf)
+		}
+	}
+
+	buf.Reset()
+	render(buf, cabDiffTemplate, diffVersions([]*RepoVersion{same}, []*RepoVersion{same}))
+	if !strings.Contains(buf.String(), "no differences") {
+		t.Errorf("empty diff output:\n%s", buf)
+	}
+}
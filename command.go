@@ -82,6 +82,12 @@ func (c *Command) Errorf(errFormat string, args ...interface{}) {
 	}
 }
 
+// Warnf prints out a formatted warning with the right prefixes.  Unlike
+// Errorf, it does not cause the command to fail.
+func (c *Command) Warnf(format string, args ...interface{}) {
+	fmt.Fprintf(stdout, c.Name+": warning: "+format+"\n", args...)
+}
+
 // Fatalf is like Errorf except the stack unwinds up to the Exec call before
 // exiting the application with status code 1.
 func (c *Command) Fatalf(errFormat string, args ...interface{}) {
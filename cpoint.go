@@ -39,10 +39,19 @@ among multiple developers sharing an $RX_DIR.  Checkpoints are created with a
 comment and a global, sequential ID which can be used to retrieve or delete it.
 
 Be careful when using checkpoint --apply, because this will update every
-repository that rx knows about!  They will generally wind up in a detached HEAD
-state, which may not be what you want.  See the --filter and --exclude options,
-which apply to both --save and --apply, to control what repositories are
-affected by the operations.`,
+repository that rx knows about!  Repositories are returned to the branch they
+were on when the checkpoint was saved if it still points to the saved revision;
+otherwise they are left detached there, unless --reset-branch is specified to
+move the branch back.  See the --filter and --exclude options, which apply to
+both --save and --apply, to control what repositories are affected by the
+operations.  Repositories with uncommitted changes are skipped unless --force
+or --stash is specified; see "rx help prescribe".  To see what --apply would do
+without changing anything, add --dry-run; see "rx help cabinet".
+
+The checkpoint file is locked while it is being read and updated, so rx runs
+sharing an $RX_DIR can save and delete checkpoints concurrently.  If another rx
+holds the lock for longer than the global --lock-timeout, the command fails
+with an error naming the process holding it.`,
 }
 
 // TODO(kevlar): make a CommandSet mechanism that is used both for the top-level
@@ -56,6 +65,9 @@ var (
 	cpointDelete  = cpointCmd.Flag.Int("delete", 0, "delete the specified checkpoint")
 	cpointFilter  = cpointCmd.Flag.String("filter", ".*", "regular expression to filter saved/restored repositories")
 	cpointExclude = cpointCmd.Flag.String("exclude", "^$", "regular expression to exclude saved/restored repositories")
+	cpointReset   = cpointCmd.Flag.Bool("reset-branch", false, "move saved branches back to the saved revision if they have moved on")
+	cpointDry     = cpointCmd.Flag.Bool("dry-run", false, "with --apply, report what would change without changing anything")
+	cpointDirty   = newDirtyPolicy(&cpointCmd.Flag)
 )
 
 func cpointFunc(cmd *Command, args ...string) {
@@ -65,6 +77,31 @@ func cpointFunc(cmd *Command, args ...string) {
 
 	var data CPointFile
 
+	filter, err := regexp.Compile(*cpointFilter)
+	if err != nil {
+		cmd.BadArgs("--filter: %s", err)
+	}
+
+	exclude, err := regexp.Compile(*cpointExclude)
+	if err != nil {
+		cmd.BadArgs("--exclude: %s", err)
+	}
+
+	if *cpointSave == "" && *cpointApply == 0 && *cpointDelete == 0 && !*cpointList {
+		cmd.BadArgs("no mode specified")
+	}
+	if *cpointDry && *cpointApply == 0 {
+		cmd.BadArgs("--dry-run requires --apply")
+	}
+
+	// Hold the lock from reading the checkpoints until they are written back,
+	// so that concurrent changes by other developers are not lost.
+	lock, err := lockRxDir("checkpoints")
+	if err != nil {
+		cmd.Fatalf("%s", err)
+	}
+	defer lock.Unlock()
+
 	// Open the checkpoint file
 	filename := filepath.Join(expandRxDir(), "checkpoints")
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
@@ -78,29 +115,29 @@ func cpointFunc(cmd *Command, args ...string) {
 		cmd.Fatalf("decoding checkpoints: %s", err)
 	}
 
-	filter, err := regexp.Compile(*cpointFilter)
-	if err != nil {
-		cmd.BadArgs("--filter: %s", err)
-	}
-
-	exclude, err := regexp.Compile(*cpointExclude)
-	if err != nil {
-		cmd.BadArgs("--exclude: %s", err)
-	}
-
 	switch {
 	case *cpointSave != "":
 		err = data.Save(*cpointSave, filter, exclude)
 	case *cpointApply != 0:
-		err = data.Apply(*cpointApply, filter, exclude)
+		// Applying doesn't change the checkpoints, and can take a while
+		file.Close()
+		lock.Unlock()
+		if *cpointDry {
+			if err := data.Plan(stdout, *cpointApply, filter, exclude, cpointDirty); err != nil {
+				cmd.Fatalf("%s", err)
+			}
+			return
+		}
+		err = data.Apply(*cpointApply, filter, exclude, cpointDirty, *cpointReset)
+		if err != nil {
+			cmd.Fatalf("%s", err)
+		}
+		return
 	case *cpointDelete != 0:
 		err = data.Delete(*cpointDelete)
 	case *cpointList:
 		data.List(stdout, *cpointNum)
 		return
-	default:
-		cmd.BadArgs("no mode specified")
-		return
 	}
 	if err != nil {
 		cmd.Fatalf("%s", err)
@@ -195,7 +232,7 @@ func (f *CPointFile) Save(comment string, filter, exclude *regexp.Regexp) error
 	return nil
 }
 
-func (f *CPointFile) Apply(id int, filter, exclude *regexp.Regexp) error {
+func (f *CPointFile) Apply(id int, filter, exclude *regexp.Regexp, policy *dirtyPolicy, resetBranch bool) error {
 	cpoint, ok := f.Checkpoints[id]
 	if !ok {
 		return fmt.Errorf("checkpoint %d does not exist", id)
@@ -211,7 +248,7 @@ func (f *CPointFile) Apply(id int, filter, exclude *regexp.Regexp) error {
 			log.Printf("  SKIP")
 			continue
 		}
-		if err := rv.Apply(); err != nil {
+		if err := rv.Apply(policy, resetBranch); err != nil {
 			log.Printf("  Failed: %s", err)
 			failed++
 		}
@@ -222,6 +259,25 @@ func (f *CPointFile) Apply(id int, filter, exclude *regexp.Regexp) error {
 	return nil
 }
 
+// Plan writes what Apply would do with the given arguments to w, without
+// changing anything.
+func (f *CPointFile) Plan(w io.Writer, id int, filter, exclude *regexp.Regexp, policy *dirtyPolicy) error {
+	cpoint, ok := f.Checkpoints[id]
+	if !ok {
+		return fmt.Errorf("checkpoint %d does not exist", id)
+	}
+
+	var plans []*pinPlan
+	for _, rv := range cpoint.Versions {
+		if !filter.MatchString(rv.Pattern) || exclude.MatchString(rv.Pattern) {
+			plans = append(plans, &pinPlan{Version: rv, Excluded: true})
+			continue
+		}
+		plans = append(plans, planPin(rv))
+	}
+	return printPlans(w, plans, policy)
+}
+
 func (f *CPointFile) Delete(id int) error {
 	if _, ok := f.Checkpoints[id]; !ok {
 		return fmt.Errorf("checkpoint %d does not exist", id)
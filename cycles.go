@@ -0,0 +1,103 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+var cyclesCmd = &Command{
+	Name:    "cycles",
+	Usage:   "[<filter>]",
+	Summary: "List dependency cycles between repositories.",
+	Help: `The cycles command lists each set of repositories which depend upon each
+other, along with the package imports between them which form the cycle.  When
+repositories are in a cycle, the order in which prescribe, update and cabinet
+process them is arbitrary.  If a <filter> regular expression is provided, only
+cycles containing a repository whose root path matches the filter are listed.
+
+By default, only package imports (including those of internal tests) are
+followed.  The --edges option selects "xtest" for only external test imports
+or "all" for both.  Cycles through external tests do not affect the build
+order, so they are not usually a problem.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is a list of (rx/graph) RepoCycles, and the default
+format is:
+
+` + ind2sp(cyclesTemplate),
+}
+
+var (
+	cyclesFormat = cyclesCmd.Flag.String("f", "", "cycles output format")
+	cyclesEdges  = cyclesCmd.Flag.String("edges", "imports", "Kinds of imports to follow (imports, xtest, or all)")
+)
+
+func cyclesFunc(cmd *Command, args ...string) {
+	kinds, err := graph.ParseEdgeKind(*cyclesEdges)
+	if err != nil {
+		cmd.BadArgs("--edges: %s", err)
+	}
+
+	var filter *regexp.Regexp
+	switch len(args) {
+	case 0:
+	case 1:
+		filter, err = regexp.Compile(args[0])
+		if err != nil {
+			cmd.BadArgs("<filter> failed to compile: %s", err)
+		}
+	default:
+		cmd.BadArgs("too many arguments")
+	}
+
+	cycles, err := Deps.RepoCycles(kinds)
+	if err != nil {
+		cmd.Fatalf("finding cycles: %s", err)
+	}
+	if filter != nil {
+		var matched []*graph.RepoCycle
+		for _, cycle := range cycles {
+			for _, repo := range cycle.Repos {
+				if filter.MatchString(repo.Root) {
+					matched = append(matched, cycle)
+					break
+				}
+			}
+		}
+		cycles = matched
+	}
+
+	switch {
+	case *cyclesFormat != "":
+		render(stdout, *cyclesFormat, cycles)
+	default:
+		render(stdout, cyclesTemplate, cycles)
+	}
+}
+
+func init() {
+	cyclesCmd.Run = cyclesFunc
+}
+
+var cyclesTemplate = `{{range $i, $c := .}}{{if $i}}
+{{end}}Cycle between {{len .Repos}} repositories:{{range .Repos}}
+  {{.}}{{end}}
+Through imports:{{range .Imports}}
+  {{.From}} -> {{.To}}{{end}}
+{{else}}No dependency cycles found.
+{{end}}`
@@ -0,0 +1,58 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestCycles(t *testing.T) {
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	defer func(old io.Writer) { stdout = old }(stdout)
+	Deps = testGraph(map[string][]string{
+		"api":    {"server"},
+		"server": {"api"},
+		"client": {"api"},
+	})
+
+	buf := new(bytes.Buffer)
+	stdout = buf
+	cyclesFunc(cyclesCmd)
+	if got, want := buf.String(), `Cycle between 2 repositories:
+  api
+  server
+Through imports:
+  api -> server
+  server -> api
+`; got != want {
+		t.Errorf("cycles:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	warnCycle(preCmd, Deps.Repository["server"])
+	if got, want := buf.String(), "prescribe: warning: server is in a dependency cycle with api; see \"rx cycles\"\n"; got != want {
+		t.Errorf("warning = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	warnCycle(preCmd, Deps.Repository["client"])
+	if got := buf.String(); got != "" {
+		t.Errorf("unexpected warning for client: %q", got)
+	}
+}
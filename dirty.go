@@ -0,0 +1,76 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"kylelemons.net/go/rx/graph"
+	"kylelemons.net/go/rx/vcs"
+)
+
+// A dirtyPolicy determines what happens to a repository with uncommitted
+// changes when it is about to be moved to another revision.
+type dirtyPolicy struct {
+	force *bool
+	stash *bool
+}
+
+// newDirtyPolicy registers the dirty working copy flags in the given flag set.
+func newDirtyPolicy(fs *flag.FlagSet) *dirtyPolicy {
+	return &dirtyPolicy{
+		force: fs.Bool("force", false, "move repositories even if they have uncommitted changes"),
+		stash: fs.Bool("stash", false, "stash uncommitted changes while moving repositories and restore them afterward"),
+	}
+}
+
+// prepare checks repo for uncommitted changes before it is moved.  Unless the
+// policy allows it, an error is returned if the repository is dirty.  A
+// repository whose version control system can't check is assumed to be clean,
+// with a warning.  The
+// returned function restores any changes which were stashed, and must be
+// called once the repository is at its final revision.
+func (p *dirtyPolicy) prepare(repo *graph.Repository) (restore func() error, err error) {
+	nothing := func() error { return nil }
+	if *p.force && !*p.stash {
+		return nothing, nil
+	}
+
+	dirty, err := repo.Dirty()
+	if err == vcs.ErrUnsupported {
+		log.Printf("Warning: %s (%s) can't be checked for uncommitted changes; assuming there are none", repo, repo.VCS)
+		return nothing, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to check for uncommitted changes: %s", repo, err)
+	}
+	if !dirty {
+		return nothing, nil
+	}
+	if !*p.stash {
+		return nil, fmt.Errorf("%s has uncommitted changes (use --stash to set them aside or --force to move it anyway)", repo)
+	}
+
+	log.Printf("Stashing uncommitted changes in %s", repo)
+	if err := repo.Stash(); err != nil {
+		return nil, err
+	}
+	return func() error {
+		log.Printf("Restoring uncommitted changes in %s", repo)
+		return repo.Unstash()
+	}, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+	"kylelemons.net/go/rx/vcs"
+)
+
+func TestDirtyPrescribe(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+
+	tmp, err := ioutil.TempDir("", "rx-dirty-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "repo")
+	os.Mkdir(dir, 0755)
+	gitRun(t, dir, "init", "-q")
+	gitCommit(t, dir, "a.go", "package a\n")
+	gitRun(t, dir, "tag", "v1")
+	gitCommit(t, dir, "b.go", "package a\n")
+	gitRun(t, dir, "tag", "v2")
+	v2 := gitRun(t, dir, "rev-parse", "HEAD")
+	gitRun(t, dir, "checkout", "-q", "v1")
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	repo := &graph.Repository{
+		Root:     dir,
+		VCS:      "git",
+		Packages: []string{"example.com/a"},
+	}
+	Deps.Repository[dir] = repo
+
+	const edit = "package a // local edit\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(edit), 0644); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if dirty, err := repo.Dirty(); err != nil || !dirty {
+		t.Fatalf("dirty = %v, %v; want true", dirty, err)
+	}
+
+	prescribe := func(args ...string) error {
+		fs := flag.NewFlagSet("prescribe", flag.ContinueOnError)
+		p := newPipeline(fs)
+		args = append([]string{"--build=false", "--test=false", "--install=false", "--cascade=false"}, args...)
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("parse: %s", err)
+		}
+		return p.prescribe(preCmd, repo, "v2")
+	}
+
+	if err := prescribe(); err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+		t.Errorf("prescribe of dirty repo: error = %v, want uncommitted changes", err)
+	}
+	if got := gitRun(t, dir, "rev-parse", "HEAD"); got == v2 {
+		t.Errorf("dirty repo was moved to v2")
+	}
+
+	if err := prescribe("--stash"); err != nil {
+		t.Fatalf("prescribe --stash: %s", err)
+	}
+	if got := gitRun(t, dir, "rev-parse", "HEAD"); got != v2 {
+		t.Errorf("head after prescribe --stash = %q, want %q (v2)", got, v2)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "a.go")); err != nil || string(b) != edit {
+		t.Errorf("a.go after prescribe --stash = %q, %v; want %q", b, err, edit)
+	}
+	if out := gitRun(t, dir, "stash", "list"); out != "" {
+		t.Errorf("stash left behind:\n%s", out)
+	}
+}
+
+func TestDirtyUnsupported(t *testing.T) {
+	d, err := vcs.NewToolDriver("rxnostatus", &vcs.Tool{
+		Command: "rxnostatus",
+		RootDir: []string{"root"},
+		ToRev:   []string{"update", "{{.}}"},
+		Current: []string{"id"},
+	})
+	if err != nil {
+		t.Fatalf("tool: %s", err)
+	}
+	vcs.Register(d)
+	defer vcs.Unregister("rxnostatus")
+
+	repo := &graph.Repository{Root: os.TempDir(), VCS: "rxnostatus", Packages: []string{"example.com/a"}}
+	if _, err := repo.Dirty(); err != vcs.ErrUnsupported {
+		t.Errorf("dirty = %v, want %v", err, vcs.ErrUnsupported)
+	}
+
+	fs := flag.NewFlagSet("dirty", flag.ContinueOnError)
+	policy := newDirtyPolicy(fs)
+	restore, err := policy.prepare(repo)
+	if err != nil {
+		t.Fatalf("prepare: %s", err)
+	}
+	if err := restore(); err != nil {
+		t.Errorf("restore: %s", err)
+	}
+}
@@ -35,23 +35,66 @@ The general usage is:
     rx [<options>] [<subcommand> [<suboptions>] [<arguments> ...]]
 
 Options:
-  --autosave = true           Automatically save dependency graph (disable for concurrent runs)
-  --max-age  = 1h0m0s         Nominal amount of time before a rescan is done
-  --rescan   = false          Force a rescan of repositories
-  --rxdir    = "$HOME/.rx"    Directory in which to save state
-  -v         = false          Turn on verbose logging
+  --autosave     = true           Automatically save dependency graph
+  --gopath       = ""             Only consider packages in this GOPATH entry (or unique substring of one)
+  --incremental  = true           Only rescan packages which have changed since the last scan
+  --lock-timeout = 30s            How long to wait for another rx to release a lock on the --rxdir
+  --max-age      = 1h0m0s         Nominal amount of time before a rescan is done
+  --rescan       = false          Force a rescan of repositories
+  --rxdir        = "$HOME/.rx"    Directory in which to save state
+  --scanner      = "golist"       How to find packages: golist (run go list) or gobuild (read them with go/build)
+  --tags         = ""             Space-separated build tags to use with --scanner=gobuild
+  -v             = false          Turn on verbose logging
 
 Commands:
     help       Help on the rx command and subcommands.
     list       List recognized repositories.
+    why        Show how one package or repository depends on another.
+    graph      Export the dependency graph.
+    cycles     List dependency cycles between repositories.
     tags       List known repository tags.
+    fetch      Poll remotes for repository updates.
     prescribe  Update the repository to the given tag/rev.
+    update     Update the repository to its newest tag.
     cabinet    Save, list, or restore dependency snapshots.
     checkpoint Save, list, or restore global repository version snapshots.
 
 Use "rx help <command>" for more help with a command.
 
 
+Version Control Systems
+
+Repositories managed by git, hg, bzr and svn are recognized automatically.
+Other version control systems can be described (and the built-in ones
+replaced) in a vcs.json file in the --rxdir.  The file contains a JSON object
+mapping the name of each system to the commands used to operate it, where
+{{.}} in an argument is replaced by a revision:
+
+    {
+        "mygit": {
+            "Command":      "mygit",
+            "HeadRev":      "HEAD",
+            "RootDir":      ["rev-parse", "--show-toplevel"],
+            "ToRev":        ["checkout", "{{.}}"],
+            "Current":      ["rev-parse", "HEAD"],
+            "Fetch":        ["fetch", "--tags"],
+            "Status":       ["status", "--porcelain", "--untracked-files=no"],
+            "Stash":        ["stash", "push", "-q"],
+            "Unstash":      ["stash", "pop", "-q"],
+            "TagList":      ["log", "--pretty=format:%H%d", "{{.}}"],
+            "TagListRegex": "^([a-z0-9]+) \\((.*)\\)",
+            "Updates":      ["log", "--pretty=format:%H%d", "--all", "^{{.}}"],
+            "UpdatesRegex": "^([a-z0-9]+) \\((.*)\\)"
+        }
+    }
+
+RootDir, ToRev and Current are required.  The TagList and Updates commands
+should list one commit per line, newest first, and the corresponding regular
+expression must capture the commit in $1 and its tags in $2.  Any output from
+Status means the working copy has uncommitted changes, which Stash and Unstash
+set aside and restore for --stash.  The file is checked every time rx starts,
+and rx refuses to run if it is invalid.
+
 See below for a description of the various sub-commands understood by rx.
 
 Help Command
@@ -82,6 +125,16 @@ their repositories.  By default, each repository is listed along with its
 dependencies and contained packages. If a <filter> regular expression is
 provided, only repositories whose root path matches the filter will be listed.
 
+The long format also shows the GOPATH entry containing each repository, and
+lists any copies of its packages in later GOPATH entries, which are hidden by
+the ones listed (rx also warns about these whenever it scans).  The global
+--gopath option restricts this and every other command to the packages in one
+GOPATH entry.
+
+Repositories containing a go.mod file are listed with their module path and
+requirements in the long format, which also shows any requirements by other
+repositories' go.mod files that the checked-out revision does not satisfy.
+
 The -f option takes a template as a format.  The data passed into the
 template invocation is an (rx/graph) Graph, and the default format is:
 
@@ -90,16 +143,155 @@ template invocation is an (rx/graph) Graph, and the default format is:
 
 If you specify --long, the format will be:
 
-  {{range .Repository}}Repository ({{.VCS}}) {{.}}:
+  {{range .Repository}}Repository ({{.VCS}}) {{.}}{{with $.Entry .}} in {{.}}{{end}}:
       Packages:{{range .Packages}}
-          {{$pkg := index $.Package .}}{{$pkg.ImportPath}}{{end}}
-  {{with $.RepoDeps .}}    Dependencies:{{range .}}
+          {{$pkg := index $.Package .}}{{$pkg.ImportPath}}{{range index $.Shadowed .}}
+              hides {{.}}{{end}}{{end}}
+  {{with .Module}}    Module {{.Path}}:{{range .Require}}
+          {{.Path}} {{.Version}}{{if .Indirect}} (indirect){{end}}{{end}}
+  {{end}}{{with $.Mismatches .}}    Required at other versions:{{range .}}
+          {{.}}{{end}}
+  {{end}}{{with $.RepoDeps .}}    Dependencies:{{range .}}
           {{.}}{{end}}
   {{end}}{{with $.RepoUsers .}}    Users:{{range .}}
           {{.}}{{end}}
   {{end}}
   {{end}}
 
+In addition to the Graph methods, the following functions are available to
+templates.  Each accepts an optional edge kind ("imports", the default, to
+follow package and internal test imports; "xtest" to follow only external
+test imports; or "all") and the closure functions accept an optional depth
+(the default, 0, means no limit):
+
+  deps <repo>          Repositories that <repo> transitively depends on
+  users <repo>         Repositories that transitively depend on <repo>
+  pkgdeps <path>       Import paths that the package transitively imports
+  pkgusers <path>      Import paths that transitively import the package
+  toposort <repos>     Repositories in dependency order, dependencies first
+  cycles <repos>       Dependency cycles among the repositories, if any
+
+For example, to list each repository with everything it needs to build:
+
+  rx list -f '{{range toposort .Repository}}{{.}}:{{range deps .}} {{.}}{{end}}
+  {{end}}'
+
+Why Command
+
+Show how one package or repository depends on another.
+
+Usage:
+    rx why <from> <to>
+
+Options:
+  --all   = false        Show all import chains, not just the shortest
+  --edges = "imports"    Kinds of imports to follow (imports, xtest, or all)
+  -f      = ""           why output format
+  --json  = false        Print the result as JSON
+  --limit = 0            Maximum number of chains to show with --all (0 for no limit)
+
+The why command prints the shortest chain of imports through which <from>
+depends on <to>.  Each of <from> and <to> can be a package import path or a
+repository, which matches any of its packages.  As with other commands, a
+repository or package can be named by its full path, the last element(s) of its
+path, or any substring of the path as long as it is unique; repositories are
+tried before packages.
+
+With --all, every import chain is printed instead, shortest first.  A chain
+ends at the first package in <to> that it reaches and does not pass through
+the same package twice.  Use --limit to cap the number of chains printed.
+
+By default, only package imports (including those of internal tests) are
+followed.  The --edges option selects "xtest" to follow only external test
+imports, or "all" to follow both; steps which are only taken by external tests
+are marked as such.
+
+With --json, the result is printed as a JSON object with From, To, and
+Chains fields.  Each chain is a list of steps with a Package field and, for
+steps through external test imports, an XTest field.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is the same object printed by --json, and the default
+format is:
+
+  {{range $i, $chain := .Chains}}{{if $i}}
+  {{end}}{{range $j, $step := $chain}}{{if $j}}  imports {{end}}{{.Package}}{{if .XTest}} (external test){{end}}
+  {{end}}{{else}}{{.From}} does not depend on {{.To}}
+  {{end}}
+
+Graph Command
+
+Export the dependency graph.
+
+Usage:
+    rx graph [<filter>]
+
+Options:
+  --depth  = 0            Maximum depth from --root (0 for no limit)
+  --edges  = "imports"    Kinds of imports to include (imports, xtest, or all)
+  --format = "dot"        Output format (dot, json, or graphml)
+  --nostd  = false        Leave out standard library packages
+  --repos  = false        Collapse packages into repositories
+  --root   = ""           Only include dependencies of this package or repository
+
+The graph command writes the package dependency graph in a format which can
+be read by other tools: Graphviz DOT (the default), JSON, or GraphML.  If a
+<filter> regular expression is provided, only packages (or repositories) whose
+path matches the filter are included.
+
+With --repos, packages are collapsed into their repositories, and there is an
+edge between two repositories if any package in one imports a package in the
+other.  Imported packages which have not been scanned (such as the standard
+library) have no repository and are left out.
+
+The --root option restricts the graph to what the given package or repository
+depends on, up to --depth imports away (or any number if --depth is 0).  The
+--nostd option leaves out the standard library.  By default, only package
+imports (including those of internal tests) are included; --edges selects
+"xtest" for only external test imports or "all" for both.  Edges only present
+because of external tests are dashed in DOT output and marked in the others.
+
+The JSON format is an object with a Nodes list, each with an ID and (for
+packages) its Repo and whether it is Standard, and an Edges list, each with
+From and To IDs and whether it is only from external tests (XTest).
+
+Example:
+  rx graph --repos --nostd | dot -Tsvg > deps.svg
+
+Cycles Command
+
+List dependency cycles between repositories.
+
+Usage:
+    rx cycles [<filter>]
+
+Options:
+  --edges = "imports"    Kinds of imports to follow (imports, xtest, or all)
+  -f      = ""           cycles output format
+
+The cycles command lists each set of repositories which depend upon each
+other, along with the package imports between them which form the cycle.  When
+repositories are in a cycle, the order in which prescribe, update and cabinet
+process them is arbitrary.  If a <filter> regular expression is provided, only
+cycles containing a repository whose root path matches the filter are listed.
+
+By default, only package imports (including those of internal tests) are
+followed.  The --edges option selects "xtest" for only external test imports
+or "all" for both.  Cycles through external tests do not affect the build
+order, so they are not usually a problem.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is a list of (rx/graph) RepoCycles, and the default
+format is:
+
+  {{range $i, $c := .}}{{if $i}}
+  {{end}}Cycle between {{len .Repos}} repositories:{{range .Repos}}
+    {{.}}{{end}}
+  Through imports:{{range .Imports}}
+    {{.From}} -> {{.To}}{{end}}
+  {{else}}No dependency cycles found.
+  {{end}}
+
 Tags Command
 
 List known repository tags.
@@ -108,22 +300,61 @@ Usage:
     rx tags <repo>
 
 Options:
-  --down = false    Only show downgrades
-  -f     = ""       tags output format
-  --long = false    Use long output format
-  --up   = false    Only show updates (overrides --down)
+  --down   = false    Only show downgrades
+  -f       = ""       tags output format
+  --latest = false    Only show the newest version
+  --long   = false    Use long output format
+  --match  = ""       Only show versions satisfying this constraint
+  --pre    = false    Include prereleases in --match and --latest
+  --sort   = false    Sort by semantic version, newest first
+  --up     = false    Only show updates (overrides --down)
 
 The tags command scans the specified repository and lists
 information about its tags.  The <repo> can be a full repository path, the last
 element of a repository path, or any substring of the path as long as it is
 unique.
 
+Tags which are semantic versions (such as "v1.2.3", "1.2" or "go1.2rc1") can be
+ordered by version with --sort, restricted to a range of versions with --match
+(for example --match=">=1.2 <2" or --match="^1.2"), or narrowed down to the
+newest version with --latest.  Prereleases are left out by --match and --latest
+unless --pre is specified.
+
+Unless -f is given, any go.mod requirements by other repositories which the
+checked-out revision does not satisfy are listed after the tags.
+
 The -f option takes a template as a format.  The data passed into the
 template invocation is an (rx/graph) TagList, and the default format is:
 
   {{range .}}{{.Rev}} {{.Name}}
   {{end}}
 
+Fetch Command
+
+Poll remotes for repository updates.
+
+Usage:
+    rx fetch [<filter>]
+
+Options:
+  -f = ""    fetch output format
+
+The fetch command pulls new revisions and tags from the default remote of
+each repository and reports those which have updates available beyond their
+current revision.  The working copy of each repository is left untouched; use
+the prescribe command to move a repository to one of the listed updates.  If a
+<filter> regular expression is provided, only repositories whose root path
+matches the filter will be fetched.  Repositories whose version control system
+can't fetch without changing the working copy (such as bzr) are skipped.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is a list of updates, each of which has the Repo (an
+(rx/graph) Repository) and its Updates (an (rx/graph) TagList).  The default
+format is:
+
+  {{range .}}{{.Repo}} :{{range .Updates}} {{.Name}}{{end}}
+  {{end}}
+
 Prescribe Command
 
 Update the repository to the given tag/rev.
@@ -134,9 +365,11 @@ Usage:
 Options:
   --build    = true     build all updated packages
   --cascade  = true     recursively process depending packages too
+  --force    = false    move repositories even if they have uncommitted changes
   --install  = true     install all updated packages
   --link     = false    link and install all updated binaries
   --rollback = true     automatically roll back failed upgrade
+  --stash    = false    stash uncommitted changes while moving repositories and restore them afterward
   --test     = true     test all updated packages
 
 The prescribe command updates the repository to the named tag or
@@ -149,29 +382,85 @@ After updating, prescribe will test, build, and the install each package
 in the updated repository.  These steps can be disabled via flags such as
 "rx prescribe --test=false repo tag".
 
+Unless --cascade=false is specified, every repository which depends upon the
+updated repository, directly or indirectly (including through external test
+packages), is then processed in the same way in dependency order.  The result
+for each dependent repository is reported, and if any of them fail the update
+is considered to have failed.  If the repository is part of a dependency cycle
+(see "rx cycles"), a warning is printed, since the order in which the other
+repositories in the cycle are processed is then arbitrary.
+
+A repository with uncommitted changes will not be updated unless --force is
+specified, in which case the changes are carried along (if the version control
+system allows it), or --stash is specified, in which case the changes are set
+aside before the update and restored once it has finished.
+
 By default, this will not link and install affected binaries; to turn this
 behavior on, see the --link option.
 
+Update Command
+
+Update the repository to its newest tag.
+
+Usage:
+    rx update <repo>
+
+Options:
+  --all      = false    update all repositories in dependency order
+  --build    = true     build all updated packages
+  --cascade  = true     recursively process depending packages too
+  --force    = false    move repositories even if they have uncommitted changes
+  --install  = true     install all updated packages
+  --link     = false    link and install all updated binaries
+  --rollback = true     automatically roll back failed upgrade
+  --stash    = false    stash uncommitted changes while moving repositories and restore them afterward
+  --test     = true     test all updated packages
+
+The update command moves the repository to the newest tag for which its
+current revision is an ancestor.  Only tags which are stable semantic versions
+(see "rx help tags") are considered, so branches and other tags are ignored; a
+repository with no newer version tag is reported and left alone.  The <repo>
+can be a full repository path, the last element of a repository path, or any
+substring as long as it is unique.
+
+After updating, the repository is tested, built, and installed exactly as it
+would be by the prescribe command, and it is rolled back if any of those steps
+fail.  The same options are available to control this behavior.
+
+If --all is specified, no <repo> may be given and every repository is updated
+in dependency order, so that each repository is updated after the repositories
+upon which it depends.  A failure to update one repository does not prevent
+the others from being updated.
+
 Cabinet Command
 
 Save, list, or restore dependency snapshots.
 
 Usage:
-    rx cabinet <repo> [<id>]
+    rx cabinet <repo> [<id> [<id2>]]
 
 Options:
-  --build = false    create a new cabinet
-  --dump  = false    list the contents of the specified cabinet
-  --list  = true     list matching cabinet files (the default)
-  --open  = false    open the specified cabinet
-  --test  = true     test package before saving and after loading cabinet
+  --build        = false    create a new cabinet
+  --convert      = false    rewrite old binary cabinets as JSON
+  --diff         = false    compare the specified cabinet with the working copies or with <id2>
+  --dry-run      = false    with --open, report what would change without changing anything
+  --dump         = false    list the contents of the specified cabinet
+  --force        = false    move repositories even if they have uncommitted changes
+  --list         = true     list matching cabinet files (the default)
+  --open         = false    open the specified cabinet
+  --reset-branch = false    move recorded branches back to the pinned revision if they have moved on
+  --stash        = false    stash uncommitted changes while moving repositories and restore them afterward
+  --test         = true     test package before saving and after loading cabinet
 
 The cabinet command saves dependency information for the given
-repository in a file within it.  The <repo> can be any piece of the
-repository root path, as long as it is unique.  The <tag> is anything
-understood by the underlying version control system as a commit, usually a
-tag, branch, or commit.  When saving, the <id> will override the default
-(based on the date) and when opening the <id> is the ID (or a unique
+repository in a file within it.  A cabinet records the revision of every
+repository that the given repository depends upon, directly or indirectly
+(including the dependencies of its tests), so that opening it reproduces the
+whole set; the dependencies are pinned in dependency order.  The <repo> can
+be any piece of the repository root path, as long as it is unique.  The <tag>
+is anything understood by the underlying version control system as a commit,
+usually a tag, branch, or commit.  When saving, the <id> will override the
+default (based on the date) and when opening the <id> is the ID (or a unique
 substring) of the cabinet to open.
 
 Cabinets are currently stored in the repository itself named as follows:
@@ -187,7 +476,33 @@ before creation fails, the cabinet will not be created.  If the test after
 restoration fails, the repositories will be reverted to their original
 revisions.
 
-The cabinet format is still in flux.
+When opening a cabinet, repositories with uncommitted changes are not
+touched unless --force or --stash is specified; see "rx help prescribe".
+
+With --dry-run, --open only reports what it would do: for each pinned
+repository found locally, its current and target revisions, whether it has
+uncommitted changes, and whether the target revision is present.  Nothing is
+fetched, moved, or tested, and repositories which have not been scanned are
+reported as needing to be downloaded.
+
+Each repository is returned to the branch (or Mercurial bookmark) it was on
+when the cabinet was created, as long as the branch still points to the same
+revision.  Otherwise, the repository is left detached at that revision unless
+--reset-branch is specified, in which case the branch is moved back to it.
+
+Cabinets are written as indented JSON with a format Version, so that they can
+be reviewed and diffed along with the rest of the repository.  Cabinets written
+by older versions of rx in a binary (gob) format can still be opened, and
+--convert rewrites those matching <id> (or all of them) as JSON.
+
+The --diff option compares the dependencies pinned by cabinet <id> with those
+pinned by cabinet <id2> or, if only <id> is given, with the revisions currently
+checked out, which shows what opening the cabinet would change.  Repositories
+pinned on only one side are listed as added (+) or removed (-), and those pinned
+at different revisions or branches as changed (~).  For a changed repository
+which is present locally, the number of commits added and removed between the
+old and new revisions is shown, along with the tags that come into or drop out
+of its history.  Revisions which have not been fetched cannot be compared.
 
 Checkpoint Command
 
@@ -197,13 +512,17 @@ Usage:
     rx checkpoint
 
 Options:
-  --apply   = 0        apply the specified checkpoint
-  --delete  = 0        delete the specified checkpoint
-  --exclude = "^$"     regular expression to exclude saved/restored repositories
-  --filter  = ".*"     regular expression to filter saved/restored repositories
-  --list    = false    list checkpoints
-  -n        = 15       number of checkpoints to list (0 for all)
-  --save    = ""       save a new checkpoint with the given comment
+  --apply        = 0        apply the specified checkpoint
+  --delete       = 0        delete the specified checkpoint
+  --dry-run      = false    with --apply, report what would change without changing anything
+  --exclude      = "^$"     regular expression to exclude saved/restored repositories
+  --filter       = ".*"     regular expression to filter saved/restored repositories
+  --force        = false    move repositories even if they have uncommitted changes
+  --list         = false    list checkpoints
+  -n             = 15       number of checkpoints to list (0 for all)
+  --reset-branch = false    move saved branches back to the saved revision if they have moved on
+  --save         = ""       save a new checkpoint with the given comment
+  --stash        = false    stash uncommitted changes while moving repositories and restore them afterward
 
 The checkpoint command is similar to the cabinet command, except
 that it has global scope and does not run tests when saving or applying.
@@ -213,10 +532,19 @@ among multiple developers sharing an $RX_DIR.  Checkpoints are created with a
 comment and a global, sequential ID which can be used to retrieve or delete it.
 
 Be careful when using checkpoint --apply, because this will update every
-repository that rx knows about!  They will generally wind up in a detached HEAD
-state, which may not be what you want.  See the --filter and --exclude options,
-which apply to both --save and --apply, to control what repositories are
-affected by the operations.
+repository that rx knows about!  Repositories are returned to the branch they
+were on when the checkpoint was saved if it still points to the saved revision;
+otherwise they are left detached there, unless --reset-branch is specified to
+move the branch back.  See the --filter and --exclude options, which apply to
+both --save and --apply, to control what repositories are affected by the
+operations.  Repositories with uncommitted changes are skipped unless --force
+or --stash is specified; see "rx help prescribe".  To see what --apply would do
+without changing anything, add --dry-run; see "rx help cabinet".
+
+The checkpoint file is locked while it is being read and updated, so rx runs
+sharing an $RX_DIR can save and delete checkpoints concurrently.  If another rx
+holds the lock for longer than the global --lock-timeout, the command fails
+with an error naming the process holding it.
 
 */
 package main
@@ -0,0 +1,115 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+
+	"kylelemons.net/go/rx/graph"
+	"kylelemons.net/go/rx/vcs"
+)
+
+var fetchCmd = &Command{
+	Name:    "fetch",
+	Usage:   "[<filter>]",
+	Summary: "Poll remotes for repository updates.",
+	Help: `The fetch command pulls new revisions and tags from the default remote of
+each repository and reports those which have updates available beyond their
+current revision.  The working copy of each repository is left untouched; use
+the prescribe command to move a repository to one of the listed updates.  If a
+<filter> regular expression is provided, only repositories whose root path
+matches the filter will be fetched.  Repositories whose version control system
+can't fetch without changing the working copy (such as bzr) are skipped.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is a list of updates, each of which has the Repo (an
+(rx/graph) Repository) and its Updates (an (rx/graph) TagList).  The default
+format is:
+
+` + ind2sp(fetchTemplate),
+}
+
+var (
+	fetchFormat = fetchCmd.Flag.String("f", "", "fetch output format")
+)
+
+// A RepoUpdate describes the updates available for a repository.
+type RepoUpdate struct {
+	Repo    *graph.Repository
+	Updates graph.TagList
+}
+
+func fetchFunc(cmd *Command, args ...string) {
+	filter := regexp.MustCompile("")
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		filter, err = regexp.Compile(args[0])
+		if err != nil {
+			cmd.BadArgs("<filter> failed to compile: %s", err)
+		}
+	default:
+		cmd.BadArgs("too many arguments")
+	}
+
+	var roots []string
+	for root := range Deps.Repository {
+		if filter.MatchString(root) {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+
+	var updates []RepoUpdate
+	for _, root := range roots {
+		repo := Deps.Repository[root]
+		log.Printf("Fetching %s", repo)
+		if err := repo.Fetch(); err == vcs.ErrUnsupported {
+			log.Printf("Skipping %s: %s can't fetch without updating the working copy", repo, repo.VCS)
+			continue
+		} else if err != nil {
+			cmd.Errorf("%s: %s", repo.Root, err)
+			continue
+		}
+		tags, err := repo.Upgrades()
+		if err != nil {
+			cmd.Errorf("%s: %s", repo.Root, err)
+			continue
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		updates = append(updates, RepoUpdate{repo, tags})
+	}
+
+	switch {
+	case *fetchFormat != "":
+		render(stdout, *fetchFormat, updates)
+	default:
+		render(stdout, fetchTemplate, updates)
+	}
+}
+
+func init() {
+	fetchCmd.Run = fetchFunc
+}
+
+var (
+	fetchTemplate = `{{range .}}{{.Repo}} :{{range .Updates}} {{.Name}}{{end}}
+{{end}}`
+)
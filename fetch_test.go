@@ -0,0 +1,136 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+	"kylelemons.net/go/rx/vcs"
+)
+
+// gitRun runs git in dir with a fixed identity, failing the test on error.
+func gitRun(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=rx", "GIT_AUTHOR_EMAIL=rx@localhost",
+		"GIT_COMMITTER_NAME=rx", "GIT_COMMITTER_EMAIL=rx@localhost",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gitCommit writes a file into dir and commits it.
+func gitCommit(t *testing.T, dir, file, contents string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0644); err != nil {
+		t.Fatalf("write %s: %s", file, err)
+	}
+	gitRun(t, dir, "add", file)
+	gitRun(t, dir, "commit", "-q", "-m", "change "+file)
+}
+
+func TestFetch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+
+	tmp, err := ioutil.TempDir("", "rx-fetch-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	origin := filepath.Join(tmp, "origin")
+	clone := filepath.Join(tmp, "clone")
+	os.Mkdir(origin, 0755)
+	gitRun(t, origin, "init", "-q")
+	gitCommit(t, origin, "a.go", "package a\n")
+	gitRun(t, origin, "tag", "v1")
+	gitRun(t, tmp, "clone", "-q", "file://"+origin, clone)
+
+	// New upstream work the clone hasn't seen yet
+	gitCommit(t, origin, "b.go", "package a\n")
+	gitRun(t, origin, "tag", "v2")
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	Deps.Repository[clone] = &graph.Repository{
+		Root:     clone,
+		VCS:      "git",
+		Packages: []string{"example.com/a"},
+	}
+
+	buf := new(bytes.Buffer)
+	defer func(old io.Writer) { stdout = old }(stdout)
+	stdout = buf
+	fetchCmd.Exec(nil)
+
+	out := buf.String()
+	if !strings.Contains(out, "example.com/a :") {
+		t.Errorf("fetch output does not list repository:\n%s", out)
+	}
+	if !strings.Contains(out, "v2") {
+		t.Errorf("fetch output does not list new tag v2:\n%s", out)
+	}
+	if strings.Contains(out, "v1") {
+		t.Errorf("fetch output lists current tag v1 as an update:\n%s", out)
+	}
+}
+
+func TestFetchUnsupported(t *testing.T) {
+	d, err := vcs.NewToolDriver("rxnofetch", &vcs.Tool{
+		Command: "rxnofetch",
+		RootDir: []string{"root"},
+		ToRev:   []string{"update", "{{.}}"},
+		Current: []string{"id"},
+	})
+	if err != nil {
+		t.Fatalf("tool: %s", err)
+	}
+	vcs.Register(d)
+	defer vcs.Unregister("rxnofetch")
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	Deps.Repository["/rxnofetch"] = &graph.Repository{
+		Root:     "/rxnofetch",
+		VCS:      "rxnofetch",
+		Packages: []string{"example.com/a"},
+	}
+
+	buf := new(bytes.Buffer)
+	defer func(old io.Writer) { stdout = old }(stdout)
+	stdout = buf
+	defer func() { fetchCmd.exit = 0 }()
+	fetchCmd.Run(fetchCmd)
+
+	if fetchCmd.exit != 0 {
+		t.Errorf("fetch failed:\n%s", buf)
+	}
+	if out := buf.String(); out != "" {
+		t.Errorf("fetch output = %q, want none", out)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+var graphCmd = &Command{
+	Name:    "graph",
+	Usage:   "[<filter>]",
+	Summary: "Export the dependency graph.",
+	Help: `The graph command writes the package dependency graph in a format which can
+be read by other tools: Graphviz DOT (the default), JSON, or GraphML.  If a
+<filter> regular expression is provided, only packages (or repositories) whose
+path matches the filter are included.
+
+With --repos, packages are collapsed into their repositories, and there is an
+edge between two repositories if any package in one imports a package in the
+other.  Imported packages which have not been scanned (such as the standard
+library) have no repository and are left out.
+
+The --root option restricts the graph to what the given package or repository
+depends on, up to --depth imports away (or any number if --depth is 0).  The
+--nostd option leaves out the standard library.  By default, only package
+imports (including those of internal tests) are included; --edges selects
+"xtest" for only external test imports or "all" for both.  Edges only present
+because of external tests are dashed in DOT output and marked in the others.
+
+The JSON format is an object with a Nodes list, each with an ID and (for
+packages) its Repo and whether it is Standard, and an Edges list, each with
+From and To IDs and whether it is only from external tests (XTest).
+
+Example:
+  rx graph --repos --nostd | dot -Tsvg > deps.svg
+`,
+}
+
+var (
+	graphFormat = graphCmd.Flag.String("format", "dot", "Output format (dot, json, or graphml)")
+	graphRepos  = graphCmd.Flag.Bool("repos", false, "Collapse packages into repositories")
+	graphRoot   = graphCmd.Flag.String("root", "", "Only include dependencies of this package or repository")
+	graphDepth  = graphCmd.Flag.Int("depth", 0, "Maximum depth from --root (0 for no limit)")
+	graphNoStd  = graphCmd.Flag.Bool("nostd", false, "Leave out standard library packages")
+	graphEdges  = graphCmd.Flag.String("edges", "imports", "Kinds of imports to include (imports, xtest, or all)")
+)
+
+// graphWriters maps the --format names to functions writing an export.
+var graphWriters = map[string]func(io.Writer, *graph.Export) error{
+	"dot":     writeDOT,
+	"json":    writeJSON,
+	"graphml": writeGraphML,
+}
+
+func graphFunc(cmd *Command, args ...string) {
+	write, ok := graphWriters[*graphFormat]
+	if !ok {
+		cmd.BadArgs("unknown --format %q", *graphFormat)
+	}
+	kinds, err := graph.ParseEdgeKind(*graphEdges)
+	if err != nil {
+		cmd.BadArgs("--edges: %s", err)
+	}
+	opts := graph.ExportOptions{
+		Repos: *graphRepos,
+		Kinds: kinds,
+		NoStd: *graphNoStd,
+		Depth: *graphDepth,
+	}
+
+	switch len(args) {
+	case 0:
+	case 1:
+		filter, err := regexp.Compile(args[0])
+		if err != nil {
+			cmd.BadArgs("<filter> failed to compile: %s", err)
+		}
+		opts.Filter = filter
+	default:
+		cmd.BadArgs("too many arguments")
+	}
+
+	if *graphRoot != "" {
+		if pkg, ok := Deps.Package[*graphRoot]; ok && !*graphRepos {
+			opts.Roots = []string{pkg.ImportPath}
+		} else if repo, err := Deps.FindRepo(*graphRoot); err == nil {
+			if *graphRepos {
+				opts.Roots = []string{repo.Root}
+			} else {
+				opts.Roots = repo.Packages
+			}
+		} else if pkg, perr := Deps.FindPackage(*graphRoot); perr == nil {
+			if *graphRepos {
+				opts.Roots = []string{pkg.RepoRoot}
+			} else {
+				opts.Roots = []string{pkg.ImportPath}
+			}
+		} else {
+			cmd.Fatalf("--root: %s; %s", err, perr)
+		}
+	}
+
+	if err := write(stdout, Deps.Export(opts)); err != nil {
+		cmd.Fatalf("write %s: %s", *graphFormat, err)
+	}
+}
+
+func init() {
+	graphCmd.Run = graphFunc
+}
+
+// writeDOT writes the export as a Graphviz digraph.
+func writeDOT(w io.Writer, e *graph.Export) error {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "digraph deps {\n")
+	for _, n := range e.Nodes {
+		fmt.Fprintf(b, "\t%s;\n", strconv.Quote(n.ID))
+	}
+	for _, edge := range e.Edges {
+		style := ""
+		if edge.XTest {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(b, "\t%s -> %s%s;\n", strconv.Quote(edge.From), strconv.Quote(edge.To), style)
+	}
+	fmt.Fprintf(b, "}\n")
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// writeJSON writes the export as indented JSON.
+func writeJSON(w io.Writer, e *graph.Export) error {
+	if e.Nodes == nil {
+		e.Nodes = []graph.ExportNode{}
+	}
+	if e.Edges == nil {
+		e.Edges = []graph.ExportEdge{}
+	}
+	js, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", js)
+	return err
+}
+
+// These types describe the subset of GraphML (http://graphml.graphdrawing.org/)
+// written by writeGraphML.
+type (
+	graphML struct {
+		XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+		Keys    []graphMLKey `xml:"key"`
+		Graph   graphMLGraph `xml:"graph"`
+	}
+	graphMLKey struct {
+		ID      string `xml:"id,attr"`
+		For     string `xml:"for,attr"`
+		Name    string `xml:"attr.name,attr"`
+		Type    string `xml:"attr.type,attr"`
+		Default string `xml:"default,omitempty"`
+	}
+	graphMLGraph struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	}
+	graphMLNode struct {
+		ID   string        `xml:"id,attr"`
+		Data []graphMLData `xml:"data"`
+	}
+	graphMLEdge struct {
+		Source string        `xml:"source,attr"`
+		Target string        `xml:"target,attr"`
+		Data   []graphMLData `xml:"data"`
+	}
+	graphMLData struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+// writeGraphML writes the export as a GraphML document.
+func writeGraphML(w io.Writer, e *graph.Export) error {
+	doc := graphML{
+		Keys: []graphMLKey{
+			{ID: "repo", For: "node", Name: "repo", Type: "string"},
+			{ID: "standard", For: "node", Name: "standard", Type: "boolean", Default: "false"},
+			{ID: "xtest", For: "edge", Name: "xtest", Type: "boolean", Default: "false"},
+		},
+		Graph: graphMLGraph{ID: "deps", EdgeDefault: "directed"},
+	}
+	for _, n := range e.Nodes {
+		node := graphMLNode{ID: n.ID}
+		if n.Repo != "" {
+			node.Data = append(node.Data, graphMLData{"repo", n.Repo})
+		}
+		if n.Standard {
+			node.Data = append(node.Data, graphMLData{"standard", "true"})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for _, edge := range e.Edges {
+		ge := graphMLEdge{Source: edge.From, Target: edge.To}
+		if edge.XTest {
+			ge.Data = append(ge.Data, graphMLData{"xtest", "true"})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, ge)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
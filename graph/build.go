@@ -0,0 +1,245 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuildContext is the context used by BuildList to find and read packages.
+// It defaults to build.Default, which honors $GOPATH, $GOOS, $GOARCH and
+// $CGO_ENABLED.
+var BuildContext = build.Default
+
+// BuildList is a Lister which reads packages with go/build instead of running
+// the go command.  Patterns and "all" only match packages in the GOPATH, which
+// is walked in the same way as the go command does (directories named
+// testdata or vendor, or starting with "." or "_", are skipped).  Unlike go
+// list, a package is only marked Incomplete if it has an error itself, not if
+// one of its dependencies does.
+func BuildList(targets ...string) ([]*Package, error) {
+	ctx := BuildContext
+
+	// Find the directories to import, in order, without duplicates
+	var dirs []string
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	var imports []string
+	for _, target := range targets {
+		switch {
+		case build.IsLocalImport(target) || filepath.IsAbs(target):
+			dir, err := filepath.Abs(target)
+			if err != nil {
+				return nil, fmt.Errorf("repo: %s", err)
+			}
+			add(dir)
+		case target == "all" || strings.Contains(target, "..."):
+			found, err := matchDirs(&ctx, target)
+			if err != nil {
+				return nil, err
+			}
+			for _, dir := range found {
+				add(dir)
+			}
+		default:
+			imports = append(imports, target)
+		}
+	}
+
+	// Import the packages with a bounded number of workers
+	work := make(chan func() *Package)
+	results := make(chan *Package)
+	wg := sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range work {
+				results <- w()
+			}
+		}()
+	}
+	go func() {
+		for _, dir := range dirs {
+			dir := dir
+			work <- func() *Package {
+				bpkg, err := ctx.ImportDir(dir, 0)
+				return convert(&ctx, bpkg, err)
+			}
+		}
+		for _, path := range imports {
+			path := path
+			work <- func() *Package {
+				bpkg, err := ctx.Import(path, "", 0)
+				if bpkg.ImportPath == "" {
+					bpkg.ImportPath = path
+				}
+				return convert(&ctx, bpkg, err)
+			}
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	var pkgs []*Package
+	for pkg := range results {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Sort(byImportPath(pkgs))
+	return pkgs, nil
+}
+
+// matchDirs returns the directories within the GOPATH containing .go files
+// whose import paths match the pattern, which is "all" or an import path
+// containing "..." wildcards.
+func matchDirs(ctx *build.Context, pattern string) ([]string, error) {
+	match := func(string) bool { return true }
+	prefix := ""
+	if pattern != "all" {
+		expr := strings.Replace(regexp.QuoteMeta(pattern), `\.\.\.`, `.*`, -1)
+		// As with the go command, "x/..." matches x itself too
+		if strings.HasSuffix(expr, "/.*") {
+			expr = strings.TrimSuffix(expr, "/.*") + "(/.*)?"
+		}
+		match = regexp.MustCompile("^" + expr + "$").MatchString
+		// Only the directory before the first wildcard needs to be walked
+		prefix = pattern[:strings.Index(pattern, "...")]
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			prefix = prefix[:i]
+		} else {
+			prefix = ""
+		}
+	}
+
+	var dirs []string
+	for _, root := range filepath.SplitList(ctx.GOPATH) {
+		if root == "" {
+			continue
+		}
+		src := filepath.Join(root, "src")
+		start := filepath.Join(src, filepath.FromSlash(prefix))
+		err := filepath.Walk(start, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if path == start && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if path != src && (skipDir(fi.Name()) || fi.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil || rel == "." {
+				return nil
+			}
+			if !match(filepath.ToSlash(rel)) {
+				return nil
+			}
+			_, hasGo, err := checkDir(path, fi, fi.ModTime())
+			if err != nil {
+				return err
+			}
+			if hasGo {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("repo: scanning %q: %s", src, err)
+		}
+	}
+	return dirs, nil
+}
+
+// convert fills a Package with the same information go list would report
+// for the imported package.
+func convert(ctx *build.Context, bpkg *build.Package, err error) *Package {
+	pkg := &Package{
+		Dir:        bpkg.Dir,
+		ImportPath: bpkg.ImportPath,
+		Name:       bpkg.Name,
+		Goroot:     bpkg.Goroot,
+		Standard:   bpkg.Goroot,
+		Root:       bpkg.Root,
+		Incomplete: err != nil,
+
+		GoFiles:      bpkg.GoFiles,
+		TestGoFiles:  bpkg.TestGoFiles,
+		XTestGoFiles: bpkg.XTestGoFiles,
+
+		Imports:      resolveImports(ctx, bpkg.Dir, bpkg.Imports),
+		TestImports:  resolveImports(ctx, bpkg.Dir, bpkg.TestImports),
+		XTestImports: resolveImports(ctx, bpkg.Dir, bpkg.XTestImports),
+	}
+	// go/build leaves a directory hidden by the same import path in an
+	// earlier GOPATH entry as a local import; name it as go list does.
+	if bpkg.ConflictDir != "" && bpkg.Root == "" {
+		for _, root := range filepath.SplitList(ctx.GOPATH) {
+			rel, err := filepath.Rel(filepath.Join(root, "src"), bpkg.Dir)
+			if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+				pkg.ImportPath, pkg.Root = filepath.ToSlash(rel), root
+				break
+			}
+		}
+	}
+	switch {
+	case bpkg.IsCommand() && bpkg.BinDir != "":
+		pkg.Target = filepath.Join(bpkg.BinDir, filepath.Base(bpkg.ImportPath))
+	case !bpkg.IsCommand():
+		pkg.Target = bpkg.PkgObj
+	}
+	return pkg
+}
+
+// resolveImports returns the import paths of the packages imported from dir,
+// taking vendor directories into account.  The pseudo-package "C" is dropped.
+func resolveImports(ctx *build.Context, dir string, imports []string) []string {
+	var resolved []string
+	for _, path := range imports {
+		if path == "C" {
+			continue
+		}
+		if dir != "" {
+			if bpkg, err := ctx.Import(path, dir, build.FindOnly); err == nil && bpkg.ImportPath != "" {
+				path = bpkg.ImportPath
+			}
+		}
+		resolved = append(resolved, path)
+	}
+	return resolved
+}
+
+type byImportPath []*Package
+
+func (b byImportPath) Len() int           { return len(b) }
+func (b byImportPath) Less(i, j int) bool { return b[i].ImportPath < b[j].ImportPath }
+func (b byImportPath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,262 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An EdgeKind selects which kinds of imports are followed through the graph.
+type EdgeKind int
+
+const (
+	ImportEdges EdgeKind = 1 << iota // Imports by packages and their internal tests
+	XTestEdges                       // Imports by external tests
+
+	AllEdges = ImportEdges | XTestEdges
+)
+
+// ParseEdgeKind parses "imports", "xtest" or "all" into an EdgeKind.
+func ParseEdgeKind(s string) (EdgeKind, error) {
+	switch s {
+	case "imports":
+		return ImportEdges, nil
+	case "xtest":
+		return XTestEdges, nil
+	case "all":
+		return AllEdges, nil
+	}
+	return 0, fmt.Errorf("unknown edge kind %q (want imports, xtest, or all)", s)
+}
+
+// edges returns the forward (or, if reverse is true, the reverse) edge maps
+// for the given kinds.
+func (g *Graph) edges(kinds EdgeKind, reverse bool) []map[string]map[string]bool {
+	var maps []map[string]map[string]bool
+	if kinds&ImportEdges != 0 {
+		if reverse {
+			maps = append(maps, g.UsedBy)
+		} else {
+			maps = append(maps, g.DependsOn)
+		}
+	}
+	if kinds&XTestEdges != 0 {
+		if reverse {
+			maps = append(maps, g.XTestUsedBy)
+		} else {
+			maps = append(maps, g.XTestDependsOn)
+		}
+	}
+	return maps
+}
+
+// closure returns the import paths reachable from start within depth steps
+// (or any number of steps, if depth <= 0) through the given edge maps.  The
+// start package is not included unless it is reachable from itself.
+func closure(start string, maps []map[string]map[string]bool, depth int) []string {
+	seen := map[string]bool{}
+	frontier := []string{start}
+	for level := 0; len(frontier) > 0 && (depth <= 0 || level < depth); level++ {
+		var next []string
+		for _, path := range frontier {
+			for _, edges := range maps {
+				for dep := range edges[path] {
+					if !seen[dep] {
+						seen[dep] = true
+						next = append(next, dep)
+					}
+				}
+			}
+		}
+		frontier = next
+	}
+
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// PackageDeps returns the import paths of the packages which the given
+// package depends upon, directly or through up to depth levels of imports
+// (any number if depth <= 0), following only the given kinds of imports.
+func (g *Graph) PackageDeps(importPath string, kinds EdgeKind, depth int) []string {
+	return closure(importPath, g.edges(kinds, false), depth)
+}
+
+// PackageUsers returns the import paths of the packages which depend upon
+// the given package, as with PackageDeps.
+func (g *Graph) PackageUsers(importPath string, kinds EdgeKind, depth int) []string {
+	return closure(importPath, g.edges(kinds, true), depth)
+}
+
+// repoClosure returns the repositories reachable from repo within depth steps
+// (any number if depth <= 0) through the given edge maps, ordered by root.
+func (g *Graph) repoClosure(repo *Repository, maps []map[string]map[string]bool, depth int) ([]*Repository, error) {
+	seen := map[*Repository]bool{repo: true}
+	var found []*Repository
+	frontier := []*Repository{repo}
+	for level := 0; len(frontier) > 0 && (depth <= 0 || level < depth); level++ {
+		var next []*Repository
+		for _, r := range frontier {
+			direct, err := g.traceDeps(r, maps...)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range direct {
+				if !seen[d] {
+					seen[d] = true
+					found = append(found, d)
+					next = append(next, d)
+				}
+			}
+		}
+		frontier = next
+	}
+	sort.Sort(byRoot(found))
+	return found, nil
+}
+
+// TransitiveRepoDeps returns the repositories which the given repository
+// depends upon, directly or through up to depth levels of repositories (any
+// number if depth <= 0), following only the given kinds of imports.  The
+// repositories are ordered by root; see TopoSort to order them by dependency.
+func (g *Graph) TransitiveRepoDeps(repo *Repository, kinds EdgeKind, depth int) ([]*Repository, error) {
+	return g.repoClosure(repo, g.edges(kinds, false), depth)
+}
+
+// TransitiveRepoUsers returns the repositories which depend upon the given
+// repository, as with TransitiveRepoDeps.
+func (g *Graph) TransitiveRepoUsers(repo *Repository, kinds EdgeKind, depth int) ([]*Repository, error) {
+	return g.repoClosure(repo, g.edges(kinds, true), depth)
+}
+
+// A CycleError lists the dependency cycles which prevented TopoSort from
+// fully ordering a list of repositories.  Each cycle lists repositories such
+// that each depends on the next, and the last depends on the first.
+type CycleError struct {
+	Cycles [][]*Repository
+}
+
+func (e *CycleError) Error() string {
+	var cycles []string
+	for _, cycle := range e.Cycles {
+		var roots []string
+		for _, repo := range cycle {
+			roots = append(roots, repo.Root)
+		}
+		roots = append(roots, cycle[0].Root)
+		cycles = append(cycles, strings.Join(roots, " -> "))
+	}
+	return fmt.Sprintf("dependency cycle: %s", strings.Join(cycles, "; "))
+}
+
+// TopoSort returns the given repositories ordered such that each repository
+// comes after all of the repositories (in the list) upon which it depends,
+// following only the given kinds of imports.  Repositories with no ordering
+// constraint between them are ordered by their root path.  If there are
+// dependency cycles, each is broken at the repository in it with the lowest
+// root path; the complete ordering is returned along with a *CycleError.
+func (g *Graph) TopoSort(repos []*Repository, kinds EdgeKind) ([]*Repository, error) {
+	maps := g.edges(kinds, false)
+
+	// Find the dependencies of each repository within the list
+	pending := map[*Repository]map[*Repository]bool{}
+	for _, repo := range repos {
+		pending[repo] = map[*Repository]bool{}
+	}
+	for _, repo := range repos {
+		deps, err := g.traceDeps(repo, maps...)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range deps {
+			if _, ok := pending[dep]; ok {
+				pending[repo][dep] = true
+			}
+		}
+	}
+
+	var cycles [][]*Repository
+	sorted := make([]*Repository, 0, len(repos))
+	for len(pending) > 0 {
+		// Pick the first ready repository, or if everything left is
+		// waiting on a cycle, the first repository in one of the cycles.
+		var next, first *Repository
+		for repo, deps := range pending {
+			if first == nil || repo.Root < first.Root {
+				first = repo
+			}
+			if len(deps) == 0 && (next == nil || repo.Root < next.Root) {
+				next = repo
+			}
+		}
+		if next == nil {
+			cycle := findCycle(first, pending)
+			cycles = append(cycles, cycle)
+			next = cycle[0]
+		}
+
+		sorted = append(sorted, next)
+		delete(pending, next)
+		for _, deps := range pending {
+			delete(deps, next)
+		}
+	}
+	if len(cycles) > 0 {
+		return sorted, &CycleError{cycles}
+	}
+	return sorted, nil
+}
+
+// findCycle follows the pending dependencies from start (always choosing the
+// lowest root) until a repository repeats, and returns the cycle found, rotated
+// to begin with its lowest root.  Every pending repository must have at least
+// one pending dependency.
+func findCycle(start *Repository, pending map[*Repository]map[*Repository]bool) []*Repository {
+	index := map[*Repository]int{}
+	var path []*Repository
+	for repo := start; ; {
+		if i, ok := index[repo]; ok {
+			cycle, low := path[i:], 0
+			for j, r := range cycle {
+				if r.Root < cycle[low].Root {
+					low = j
+				}
+			}
+			return append(cycle[low:], cycle[:low]...)
+		}
+		index[repo] = len(path)
+		path = append(path, repo)
+
+		var next *Repository
+		for dep := range pending[repo] {
+			if next == nil || dep.Root < next.Root {
+				next = dep
+			}
+		}
+		repo = next
+	}
+}
+
+type byRoot []*Repository
+
+func (b byRoot) Len() int           { return len(b) }
+func (b byRoot) Less(i, j int) bool { return b[i].Root < b[j].Root }
+func (b byRoot) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,129 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+// closureGraph returns a graph with one package per repository:
+//
+//	a -> b -> c -> d, and a's external tests import e.
+func closureGraph() *Graph {
+	g := New()
+	for _, root := range []string{"a", "b", "c", "d", "e"} {
+		g.addRepository(root, "git")
+	}
+	g.addPackage(&Package{ImportPath: "a", RepoRoot: "a", Imports: []string{"b"}, XTestImports: []string{"a", "e"}})
+	g.addPackage(&Package{ImportPath: "b", RepoRoot: "b", Imports: []string{"c"}})
+	g.addPackage(&Package{ImportPath: "c", RepoRoot: "c", TestImports: []string{"d"}})
+	g.addPackage(&Package{ImportPath: "d", RepoRoot: "d"})
+	g.addPackage(&Package{ImportPath: "e", RepoRoot: "e"})
+	return g
+}
+
+func TestPackageClosure(t *testing.T) {
+	g := closureGraph()
+
+	tests := []struct {
+		Desc string
+		Got  []string
+		Want []string
+	}{
+		{"deps(a)", g.PackageDeps("a", ImportEdges, 0), []string{"b", "c", "d"}},
+		{"deps(a, 2)", g.PackageDeps("a", ImportEdges, 2), []string{"b", "c"}},
+		{"deps(a, all)", g.PackageDeps("a", AllEdges, 0), []string{"b", "c", "d", "e"}},
+		{"deps(a, xtest)", g.PackageDeps("a", XTestEdges, 0), []string{"e"}},
+		{"users(d)", g.PackageUsers("d", ImportEdges, 0), []string{"a", "b", "c"}},
+		{"users(d, 1)", g.PackageUsers("d", ImportEdges, 1), []string{"c"}},
+		{"users(e)", g.PackageUsers("e", ImportEdges, 0), []string{}},
+		{"users(e, all)", g.PackageUsers("e", AllEdges, 0), []string{"a"}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.Got, test.Want) {
+			t.Errorf("%s = %q, want %q", test.Desc, test.Got, test.Want)
+		}
+	}
+}
+
+func TestRepoClosure(t *testing.T) {
+	g := closureGraph()
+
+	tests := []struct {
+		Desc  string
+		Trace func(*Repository, EdgeKind, int) ([]*Repository, error)
+		Repo  string
+		Kinds EdgeKind
+		Depth int
+		Want  []string
+	}{
+		{"deps(a)", g.TransitiveRepoDeps, "a", ImportEdges, 0, []string{"b", "c", "d"}},
+		{"deps(a, 1)", g.TransitiveRepoDeps, "a", ImportEdges, 1, []string{"b"}},
+		{"deps(a, all)", g.TransitiveRepoDeps, "a", AllEdges, 0, []string{"b", "c", "d", "e"}},
+		{"users(c)", g.TransitiveRepoUsers, "c", ImportEdges, 0, []string{"a", "b"}},
+		{"users(e)", g.TransitiveRepoUsers, "e", ImportEdges, 0, nil},
+		{"users(e, xtest)", g.TransitiveRepoUsers, "e", XTestEdges, 0, []string{"a"}},
+	}
+	for _, test := range tests {
+		repos, err := test.Trace(g.Repository[test.Repo], test.Kinds, test.Depth)
+		if err != nil {
+			t.Errorf("%s: %s", test.Desc, err)
+			continue
+		}
+		if got := roots(repos); !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%s = %q, want %q", test.Desc, got, test.Want)
+		}
+	}
+}
+
+func TestTopoSort(t *testing.T) {
+	g := closureGraph()
+
+	var all []*Repository
+	for _, root := range []string{"e", "a", "d", "c", "b"} {
+		all = append(all, g.Repository[root])
+	}
+	sorted, err := g.TopoSort(all, ImportEdges)
+	if err != nil {
+		t.Fatalf("sort: %s", err)
+	}
+	var got []string
+	for _, repo := range sorted {
+		got = append(got, repo.Root)
+	}
+	if want := []string{"d", "c", "b", "a", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %q, want %q", got, want)
+	}
+
+	// e imports b, closing a cycle b -> c -> d -> e -> b only when external
+	// tests of a are not involved.
+	g.addPackage(&Package{ImportPath: "d", RepoRoot: "d", Imports: []string{"e"}})
+	g.addPackage(&Package{ImportPath: "e", RepoRoot: "e", Imports: []string{"b"}})
+	sorted, err = g.TopoSort(all, ImportEdges)
+	cycles, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("sort with cycle: error = %v, want a *CycleError", err)
+	}
+	if len(sorted) != len(all) {
+		t.Errorf("sort with cycle returned %d repositories, want %d", len(sorted), len(all))
+	}
+	if got, want := cycles.Error(), "dependency cycle: b -> c -> d -> e -> b"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+	if sorted, err := g.SortRepos(all); err != nil || len(sorted) != len(all) {
+		t.Errorf("SortRepos with cycle = %d repos, %v; want %d, nil", len(sorted), err, len(all))
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"sort"
+)
+
+// A RepoCycle is a set of repositories which all depend upon each other: a
+// strongly connected component of the repository graph with more than one
+// repository in it.
+type RepoCycle struct {
+	Repos   []*Repository // The repositories in the cycle, ordered by root
+	Imports []Import      // The imports between them, ordered by importer
+}
+
+// An Import is an import of one package by another.
+type Import struct {
+	From, To string
+}
+
+// Contains returns whether repo is part of the cycle.
+func (c *RepoCycle) Contains(repo *Repository) bool {
+	for _, r := range c.Repos {
+		if r == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoCycles returns the dependency cycles among all of the repositories in the
+// graph, following only the given kinds of imports.  The cycles are ordered by
+// the root of their first repository.
+func (g *Graph) RepoCycles(kinds EdgeKind) ([]*RepoCycle, error) {
+	maps := g.edges(kinds, false)
+
+	var repos []*Repository
+	for _, repo := range g.Repository {
+		repos = append(repos, repo)
+	}
+	sort.Sort(byRoot(repos))
+
+	// Tarjan's strongly connected components algorithm
+	var (
+		index   = map[*Repository]int{}
+		low     = map[*Repository]int{}
+		onStack = map[*Repository]bool{}
+		stack   []*Repository
+		cycles  []*RepoCycle
+	)
+	var visit func(repo *Repository) error
+	visit = func(repo *Repository) error {
+		index[repo] = len(index)
+		low[repo] = index[repo]
+		stack = append(stack, repo)
+		onStack[repo] = true
+
+		deps, err := g.traceDeps(repo, maps...)
+		if err != nil {
+			return err
+		}
+		sort.Sort(byRoot(deps))
+		for _, dep := range deps {
+			if _, ok := index[dep]; !ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
+				if low[dep] < low[repo] {
+					low[repo] = low[dep]
+				}
+			} else if onStack[dep] && index[dep] < low[repo] {
+				low[repo] = index[dep]
+			}
+		}
+
+		if low[repo] != index[repo] {
+			return nil
+		}
+		var component []*Repository
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == repo {
+				break
+			}
+		}
+		if len(component) > 1 {
+			cycles = append(cycles, g.repoCycle(component, maps))
+		}
+		return nil
+	}
+	for _, repo := range repos {
+		if _, ok := index[repo]; !ok {
+			if err := visit(repo); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Sort(byFirstRoot(cycles))
+	return cycles, nil
+}
+
+// RepoCycle returns the dependency cycle which repo is part of, following only
+// the given kinds of imports, or nil if it is not part of one.
+func (g *Graph) RepoCycle(repo *Repository, kinds EdgeKind) (*RepoCycle, error) {
+	cycles, err := g.RepoCycles(kinds)
+	if err != nil {
+		return nil, err
+	}
+	for _, cycle := range cycles {
+		if cycle.Contains(repo) {
+			return cycle, nil
+		}
+	}
+	return nil, nil
+}
+
+// repoCycle collects the imports between the packages of the given repositories.
+func (g *Graph) repoCycle(repos []*Repository, maps []map[string]map[string]bool) *RepoCycle {
+	sort.Sort(byRoot(repos))
+	member := map[string]bool{}
+	for _, repo := range repos {
+		member[repo.Root] = true
+	}
+
+	cycle := &RepoCycle{Repos: repos}
+	for _, repo := range repos {
+		for _, path := range repo.Packages {
+			for _, dep := range sortedEdges(path, maps) {
+				pkg, ok := g.Package[dep]
+				if ok && pkg.RepoRoot != repo.Root && member[pkg.RepoRoot] {
+					cycle.Imports = append(cycle.Imports, Import{path, dep})
+				}
+			}
+		}
+	}
+	sort.Sort(byImporter(cycle.Imports))
+	return cycle
+}
+
+type byFirstRoot []*RepoCycle
+
+func (b byFirstRoot) Len() int           { return len(b) }
+func (b byFirstRoot) Less(i, j int) bool { return b[i].Repos[0].Root < b[j].Repos[0].Root }
+func (b byFirstRoot) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type byImporter []Import
+
+func (b byImporter) Len() int      { return len(b) }
+func (b byImporter) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byImporter) Less(i, j int) bool {
+	if b[i].From != b[j].From {
+		return b[i].From < b[j].From
+	}
+	return b[i].To < b[j].To
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepoCycles(t *testing.T) {
+	g := New()
+	for _, root := range []string{"a", "b", "c", "d", "e", "f"} {
+		g.addRepository(root, "git")
+	}
+	// a <-> b <- c, c -> d -> e -> c, and f's external tests import e,
+	// which imports f.
+	g.addPackage(&Package{ImportPath: "a/x", RepoRoot: "a", Imports: []string{"b/x"}})
+	g.addPackage(&Package{ImportPath: "a/y", RepoRoot: "a"})
+	g.addPackage(&Package{ImportPath: "b/x", RepoRoot: "b", TestImports: []string{"a/y"}})
+	g.addPackage(&Package{ImportPath: "c", RepoRoot: "c", Imports: []string{"b/x", "d"}})
+	g.addPackage(&Package{ImportPath: "d", RepoRoot: "d", Imports: []string{"e"}})
+	g.addPackage(&Package{ImportPath: "e", RepoRoot: "e", Imports: []string{"c", "f"}})
+	g.addPackage(&Package{ImportPath: "f", RepoRoot: "f", XTestImports: []string{"e"}})
+
+	type cycle struct {
+		Repos   []string
+		Imports []Import
+	}
+	summarize := func(cycles []*RepoCycle) []cycle {
+		var out []cycle
+		for _, c := range cycles {
+			var roots []string
+			for _, repo := range c.Repos {
+				roots = append(roots, repo.Root)
+			}
+			out = append(out, cycle{roots, c.Imports})
+		}
+		return out
+	}
+
+	cycles, err := g.RepoCycles(ImportEdges)
+	if err != nil {
+		t.Fatalf("cycles: %s", err)
+	}
+	want := []cycle{
+		{[]string{"a", "b"}, []Import{{"a/x", "b/x"}, {"b/x", "a/y"}}},
+		{[]string{"c", "d", "e"}, []Import{{"c", "d"}, {"d", "e"}, {"e", "c"}}},
+	}
+	if got := summarize(cycles); !reflect.DeepEqual(got, want) {
+		t.Errorf("cycles = %+v, want %+v", got, want)
+	}
+
+	cycles, err = g.RepoCycles(AllEdges)
+	if err != nil {
+		t.Fatalf("cycles with xtests: %s", err)
+	}
+	want[1] = cycle{[]string{"c", "d", "e", "f"}, []Import{{"c", "d"}, {"d", "e"}, {"e", "c"}, {"e", "f"}, {"f", "e"}}}
+	if got := summarize(cycles); !reflect.DeepEqual(got, want) {
+		t.Errorf("cycles with xtests = %+v, want %+v", got, want)
+	}
+
+	for root, want := range map[string]bool{"a": true, "c": true, "f": false} {
+		c, err := g.RepoCycle(g.Repository[root], ImportEdges)
+		if err != nil {
+			t.Fatalf("cycle(%s): %s", root, err)
+		}
+		if got := c != nil; got != want {
+			t.Errorf("cycle(%s) found = %v, want %v", root, got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ExportOptions select the part of a graph to export.
+type ExportOptions struct {
+	Repos  bool           // Collapse packages into their repositories
+	Kinds  EdgeKind       // The kinds of imports to include
+	NoStd  bool           // Leave out standard library packages
+	Roots  []string       // If set, only include nodes reachable from these
+	Depth  int            // Maximum number of imports from Roots (0 for any)
+	Filter *regexp.Regexp // If set, only include nodes which match
+}
+
+// An Export is a snapshot of (part of) the dependency graph in a form suitable
+// for writing out to other tools.  Its nodes and edges are sorted.
+type Export struct {
+	Nodes []ExportNode
+	Edges []ExportEdge
+}
+
+// An ExportNode is a package or repository in an Export.
+type ExportNode struct {
+	ID       string // The import path or repository root
+	Repo     string `json:",omitempty"` // The repository containing a package, if known
+	Standard bool   `json:",omitempty"` // The package is in the standard library
+}
+
+// An ExportEdge is an import (or, for repositories, any number of imports)
+// between two nodes of an Export.
+type ExportEdge struct {
+	From, To string
+	XTest    bool `json:",omitempty"` // Only imported by external tests
+}
+
+// Export returns the nodes and edges of the graph selected by opts.  Imported
+// packages which were not scanned are included as nodes without a repository;
+// when collapsing into repositories, they are left out.
+func (g *Graph) Export(opts ExportOptions) *Export {
+	nodes := map[string]ExportNode{}
+	edges := map[[2]string]bool{} // edge -> only from external tests
+
+	node := func(path string) (string, bool) {
+		pkg, ok := g.Package[path]
+		std := isStandard(path)
+		if ok {
+			std = pkg.Standard
+		}
+		if std && opts.NoStd {
+			return "", false
+		}
+		if opts.Repos {
+			if !ok || pkg.RepoRoot == "" {
+				return "", false
+			}
+			if _, seen := nodes[pkg.RepoRoot]; !seen {
+				nodes[pkg.RepoRoot] = ExportNode{ID: pkg.RepoRoot}
+			}
+			return pkg.RepoRoot, true
+		}
+		if _, seen := nodes[path]; !seen {
+			n := ExportNode{ID: path, Standard: std}
+			if ok {
+				n.Repo = pkg.RepoRoot
+			}
+			nodes[path] = n
+		}
+		return path, true
+	}
+	add := func(forward map[string]map[string]bool, xtest bool) {
+		for from, deps := range forward {
+			a, ok := node(from)
+			if !ok {
+				continue
+			}
+			for to := range deps {
+				b, ok := node(to)
+				if !ok || a == b {
+					continue
+				}
+				key := [2]string{a, b}
+				if only, seen := edges[key]; !seen || only {
+					edges[key] = xtest
+				}
+			}
+		}
+	}
+	for path := range g.Package {
+		node(path)
+	}
+	if opts.Kinds&ImportEdges != 0 {
+		add(g.DependsOn, false)
+	}
+	if opts.Kinds&XTestEdges != 0 {
+		add(g.XTestDependsOn, true)
+	}
+
+	keep := func(id string) bool {
+		return opts.Filter == nil || opts.Filter.MatchString(id)
+	}
+	if len(opts.Roots) > 0 {
+		out := map[string][]string{}
+		for e := range edges {
+			out[e[0]] = append(out[e[0]], e[1])
+		}
+		reached := map[string]bool{}
+		var frontier []string
+		for _, root := range opts.Roots {
+			if _, ok := nodes[root]; ok && !reached[root] {
+				reached[root] = true
+				frontier = append(frontier, root)
+			}
+		}
+		for level := 0; len(frontier) > 0 && (opts.Depth <= 0 || level < opts.Depth); level++ {
+			var next []string
+			for _, id := range frontier {
+				for _, dep := range out[id] {
+					if !reached[dep] {
+						reached[dep] = true
+						next = append(next, dep)
+					}
+				}
+			}
+			frontier = next
+		}
+		filter := keep
+		keep = func(id string) bool { return reached[id] && filter(id) }
+	}
+
+	export := new(Export)
+	for id, n := range nodes {
+		if keep(id) {
+			export.Nodes = append(export.Nodes, n)
+		}
+	}
+	for e, xtest := range edges {
+		if keep(e[0]) && keep(e[1]) {
+			export.Edges = append(export.Edges, ExportEdge{e[0], e[1], xtest})
+		}
+	}
+	sort.Sort(byNodeID(export.Nodes))
+	sort.Sort(byEdge(export.Edges))
+	return export
+}
+
+// isStandard guesses whether an import path which has not been scanned is in
+// the standard library, which is the case if its first element has no dot.
+func isStandard(path string) bool {
+	first := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		first = path[:i]
+	}
+	return !strings.Contains(first, ".")
+}
+
+type byNodeID []ExportNode
+
+func (b byNodeID) Len() int           { return len(b) }
+func (b byNodeID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+func (b byNodeID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type byEdge []ExportEdge
+
+func (b byEdge) Len() int      { return len(b) }
+func (b byEdge) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byEdge) Less(i, j int) bool {
+	if b[i].From != b[j].From {
+		return b[i].From < b[j].From
+	}
+	return b[i].To < b[j].To
+}
@@ -0,0 +1,103 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	g := New()
+	g.addRepository("r", "git")
+	g.addRepository("s", "git")
+	g.addPackage(&Package{ImportPath: "r/a", RepoRoot: "r", Imports: []string{"fmt", "r/b"}, XTestImports: []string{"s/y"}})
+	g.addPackage(&Package{ImportPath: "r/b", RepoRoot: "r", Imports: []string{"s/x"}})
+	g.addPackage(&Package{ImportPath: "s/x", RepoRoot: "s", Imports: []string{"example.com/ext"}})
+	g.addPackage(&Package{ImportPath: "s/y", RepoRoot: "s"})
+
+	ids := func(e *Export) (nodes, edges []string) {
+		for _, n := range e.Nodes {
+			nodes = append(nodes, n.ID)
+		}
+		for _, edge := range e.Edges {
+			s := edge.From + "->" + edge.To
+			if edge.XTest {
+				s += " (xtest)"
+			}
+			edges = append(edges, s)
+		}
+		return nodes, edges
+	}
+
+	tests := []struct {
+		Desc  string
+		Opts  ExportOptions
+		Nodes []string
+		Edges []string
+	}{
+		{
+			Desc:  "packages",
+			Opts:  ExportOptions{Kinds: ImportEdges},
+			Nodes: []string{"example.com/ext", "fmt", "r/a", "r/b", "s/x", "s/y"},
+			Edges: []string{"r/a->fmt", "r/a->r/b", "r/b->s/x", "s/x->example.com/ext"},
+		},
+		{
+			Desc:  "nostd all edges",
+			Opts:  ExportOptions{Kinds: AllEdges, NoStd: true},
+			Nodes: []string{"example.com/ext", "r/a", "r/b", "s/x", "s/y"},
+			Edges: []string{"r/a->r/b", "r/a->s/y (xtest)", "r/b->s/x", "s/x->example.com/ext"},
+		},
+		{
+			Desc:  "repos",
+			Opts:  ExportOptions{Kinds: AllEdges, Repos: true},
+			Nodes: []string{"r", "s"},
+			Edges: []string{"r->s"},
+		},
+		{
+			Desc:  "root and depth",
+			Opts:  ExportOptions{Kinds: ImportEdges, Roots: []string{"r/a"}, Depth: 2, NoStd: true},
+			Nodes: []string{"r/a", "r/b", "s/x"},
+			Edges: []string{"r/a->r/b", "r/b->s/x"},
+		},
+		{
+			Desc:  "filter",
+			Opts:  ExportOptions{Kinds: ImportEdges, Filter: regexp.MustCompile(`^[rs]/`)},
+			Nodes: []string{"r/a", "r/b", "s/x", "s/y"},
+			Edges: []string{"r/a->r/b", "r/b->s/x"},
+		},
+	}
+	for _, test := range tests {
+		nodes, edges := ids(g.Export(test.Opts))
+		if !reflect.DeepEqual(nodes, test.Nodes) {
+			t.Errorf("%s: nodes = %q, want %q", test.Desc, nodes, test.Nodes)
+		}
+		if !reflect.DeepEqual(edges, test.Edges) {
+			t.Errorf("%s: edges = %q, want %q", test.Desc, edges, test.Edges)
+		}
+	}
+
+	e := g.Export(ExportOptions{Kinds: ImportEdges})
+	if got, want := e.Nodes[0], (ExportNode{ID: "example.com/ext"}); got != want {
+		t.Errorf("unscanned node = %+v, want %+v", got, want)
+	}
+	if got, want := e.Nodes[1], (ExportNode{ID: "fmt", Standard: true}); got != want {
+		t.Errorf("standard node = %+v, want %+v", got, want)
+	}
+	if got, want := e.Nodes[2], (ExportNode{ID: "r/a", Repo: "r"}); got != want {
+		t.Errorf("package node = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GOPATH returns the entries of the GOPATH, in order.
+func GOPATH() []string {
+	var entries []string
+	for _, dir := range filepath.SplitList(build.Default.GOPATH) {
+		if dir != "" {
+			entries = append(entries, filepath.Clean(dir))
+		}
+	}
+	return entries
+}
+
+// FindGOPATH returns the GOPATH entry matching the given key, which can be the
+// entry itself, its last element, or any unique substring of it.
+func FindGOPATH(key string) (string, error) {
+	entries := GOPATH()
+	for _, entry := range entries {
+		if entry == filepath.Clean(key) {
+			return entry, nil
+		}
+	}
+
+	var found []string
+	for _, entry := range entries {
+		if filepath.Base(entry) == key {
+			found = append(found, entry)
+		}
+	}
+	if len(found) == 0 {
+		for _, entry := range entries {
+			if strings.Contains(entry, key) {
+				found = append(found, entry)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no GOPATH entry matches %q (GOPATH is %q)", key, build.Default.GOPATH)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("non-unique GOPATH entry specifier %q", key)
+}
+
+// gopathIndex returns the position of the given GOPATH entry, or the number of
+// entries if it is not one of them.
+func gopathIndex(root string) int {
+	entries := GOPATH()
+	for i, entry := range entries {
+		if entry == filepath.Clean(root) {
+			return i
+		}
+	}
+	return len(entries)
+}
+
+// shadowDirs returns the directories containing Go files for pkg's import path
+// in GOPATH entries other than its own, which are hidden by pkg.
+func shadowDirs(pkg *Package) []string {
+	if pkg.Standard || pkg.Root == "" {
+		return nil
+	}
+	var dirs []string
+	for _, entry := range GOPATH() {
+		dir := filepath.Join(entry, "src", filepath.FromSlash(pkg.ImportPath))
+		if entry == filepath.Clean(pkg.Root) || dir == pkg.Dir {
+			continue
+		}
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		if _, hasGo, err := checkDir(dir, fi, fi.ModTime()); err == nil && hasGo {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// findShadows records the hidden copies of every package in the graph.
+func (g *Graph) findShadows() {
+	g.Shadowed = map[string][]string{}
+	for path, pkg := range g.Package {
+		if dirs := shadowDirs(pkg); len(dirs) > 0 {
+			g.Shadowed[path] = dirs
+		}
+	}
+}
+
+// preferListed filters listed packages so that when the same import path was
+// found in more than one GOPATH entry, only the one from the earliest entry
+// (the one the go command would use) is kept.  The order is preserved.
+func preferListed(found []listed) []listed {
+	best := map[string]int{}
+	for i, f := range found {
+		if j, ok := best[f.ImportPath]; !ok || gopathIndex(f.Root) < gopathIndex(found[j].Root) {
+			best[f.ImportPath] = i
+		}
+	}
+	var kept []listed
+	for i, f := range found {
+		if best[f.ImportPath] == i {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// shadowedBy returns true if pkg is a copy of a package already in the graph
+// which comes from an earlier GOPATH entry.  Otherwise, any other copy already
+// in the graph is removed so that pkg can take its place.
+func (g *Graph) shadowedBy(pkg *Package) bool {
+	old, ok := g.Package[pkg.ImportPath]
+	if !ok || old.Dir == pkg.Dir {
+		return false
+	}
+	if gopathIndex(old.Root) <= gopathIndex(pkg.Root) {
+		if _, err := os.Stat(old.Dir); err == nil {
+			return true
+		}
+	}
+	g.removePackage(old.ImportPath)
+	return false
+}
+
+// A Shadow is a package which is hidden by a package with the same import
+// path in an earlier GOPATH entry.
+type Shadow struct {
+	ImportPath string
+	Dir        string // The directory of the hidden copy
+	ActiveDir  string // The directory of the copy that is used
+}
+
+// Shadows returns every hidden package found by the last scan, ordered by
+// import path.
+func (g *Graph) Shadows() []Shadow {
+	var shadows []Shadow
+	for path, dirs := range g.Shadowed {
+		pkg, ok := g.Package[path]
+		if !ok {
+			continue
+		}
+		for _, dir := range dirs {
+			shadows = append(shadows, Shadow{path, dir, pkg.Dir})
+		}
+	}
+	sort.Sort(byShadow(shadows))
+	return shadows
+}
+
+// Entry returns the GOPATH entry containing repo, or "" if it is unknown.
+func (g *Graph) Entry(repo *Repository) string {
+	for _, path := range repo.Packages {
+		if pkg, ok := g.Package[path]; ok && pkg.Root != "" {
+			return pkg.Root
+		}
+	}
+	return ""
+}
+
+// Scope returns a copy of the graph which only contains the packages in the
+// given GOPATH entry, the repositories containing them, and their imports.
+// The repositories are copies, but the packages are shared with g.
+func (g *Graph) Scope(entry string) *Graph {
+	entry = filepath.Clean(entry)
+	s := New()
+	s.LastScan = g.LastScan
+	for path, pkg := range g.Package {
+		if pkg.Root == "" || filepath.Clean(pkg.Root) != entry {
+			continue
+		}
+		s.Package[path] = pkg
+		if dirs, ok := g.Shadowed[path]; ok {
+			s.Shadowed[path] = dirs
+		}
+		for dep := range g.DependsOn[path] {
+			s.addImport(path, dep)
+		}
+		for dep := range g.XTestDependsOn[path] {
+			s.addXTestImport(path, dep)
+		}
+	}
+	for root, repo := range g.Repository {
+		var pkgs []string
+		for _, path := range repo.Packages {
+			if _, ok := s.Package[path]; ok {
+				pkgs = append(pkgs, path)
+			}
+		}
+		if len(pkgs) == 0 {
+			continue
+		}
+		r := *repo
+		r.Packages = pkgs
+		s.Repository[root] = &r
+	}
+	return s
+}
+
+type byShadow []Shadow
+
+func (b byShadow) Len() int      { return len(b) }
+func (b byShadow) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byShadow) Less(i, j int) bool {
+	if b[i].ImportPath != b[j].ImportPath {
+		return b[i].ImportPath < b[j].ImportPath
+	}
+	return b[i].Dir < b[j].Dir
+}
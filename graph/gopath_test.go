@@ -0,0 +1,107 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestShadows(t *testing.T) {
+	first, done := tempGOPATH(t)
+	defer done()
+	second := filepath.Join(first, "second")
+
+	// Use two GOPATH entries, where the first hides second's copy of lib
+	gopath := first + string(filepath.ListSeparator) + second
+	os.Setenv("GOPATH", gopath)
+	build.Default.GOPATH, BuildContext.GOPATH = gopath, gopath
+
+	writeTree(t, filepath.Join(first, "src"), map[string]string{
+		"example.com/lib/lib.go": "package lib\n",
+		"example.com/app/app.go": "package app\n\nimport _ \"example.com/lib\"\n",
+	})
+	writeTree(t, filepath.Join(second, "src"), map[string]string{
+		"example.com/lib/lib.go": "package lib\n\nimport _ \"example.com/other\"\n",
+		"example.com/other/o.go": "package other\n",
+	})
+	for _, dir := range []string{
+		filepath.Join(first, "src", "example.com", "lib"),
+		filepath.Join(first, "src", "example.com", "app"),
+		filepath.Join(second, "src", "example.com", "lib"),
+		filepath.Join(second, "src", "example.com", "other"),
+	} {
+		gitInit(t, dir)
+	}
+
+	if got, err := FindGOPATH("second"); err != nil || got != second {
+		t.Errorf("FindGOPATH(second) = %q, %v; want %q", got, err, second)
+	}
+
+	defer func(old Lister) { ListPackages = old }(ListPackages)
+	for name, lister := range Listers {
+		ListPackages = lister
+		g := New()
+		if err := g.Scan("all"); err != nil {
+			t.Fatalf("%s: scan: %s", name, err)
+		}
+
+		lib, ok := g.Package["example.com/lib"]
+		if !ok {
+			t.Fatalf("%s: lib not found", name)
+		}
+		if got, want := lib.Dir, filepath.Join(first, "src", "example.com", "lib"); got != want {
+			t.Errorf("%s: lib dir = %q, want %q", name, got, want)
+		}
+		if got := len(g.DependsOn["example.com/lib"]); got != 0 {
+			t.Errorf("%s: the hidden copy's imports were merged: %v", name, g.DependsOn["example.com/lib"])
+		}
+		if _, ok := g.Repository[filepath.Join(second, "src", "example.com", "lib")]; ok {
+			t.Errorf("%s: the hidden copy's repository was added", name)
+		}
+
+		want := []Shadow{{
+			ImportPath: "example.com/lib",
+			Dir:        filepath.Join(second, "src", "example.com", "lib"),
+			ActiveDir:  lib.Dir,
+		}}
+		if got := g.Shadows(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: shadows = %+v, want %+v", name, got, want)
+		}
+
+		var pkgs []string
+		for path := range g.Scope(second).Package {
+			pkgs = append(pkgs, path)
+		}
+		sort.Strings(pkgs)
+		if want := []string{"example.com/other"}; !reflect.DeepEqual(pkgs, want) {
+			t.Errorf("%s: scoped packages = %q, want %q", name, pkgs, want)
+		}
+		scoped := g.Scope(first)
+		if got, want := len(scoped.Repository), 2; got != want {
+			t.Errorf("%s: scoped to first entry, got %d repositories, want %d", name, got, want)
+		}
+		if !scoped.UsedBy["example.com/lib"]["example.com/app"] {
+			t.Errorf("%s: scoped graph is missing app's import of lib", name)
+		}
+		if got, want := g.Entry(g.Repository[lib.RepoRoot]), first; got != want {
+			t.Errorf("%s: entry = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -29,22 +29,36 @@ type Graph struct {
 	// If "a" imports "b", UsedBy["b"]["a"] == true
 	UsedBy map[string]map[string]bool
 
+	// If the external tests of "a" (package a_test) import "b",
+	// XTestDependsOn["a"]["b"] == true and XTestUsedBy["b"]["a"] == true.
+	// These are kept apart from other imports because they may form
+	// cycles (b may itself import a).
+	XTestDependsOn map[string]map[string]bool
+	XTestUsedBy    map[string]map[string]bool
+
 	// Package["import/path"] = &Package{...}
 	Package map[string]*Package
 
 	// Repository["repo/path"] = &Repository{...}
 	Repository map[string]*Repository
 
+	// If "a" is in more than one GOPATH entry, Shadowed["a"] lists the
+	// directories of the copies hidden by the one in Package["a"].
+	Shadowed map[string][]string
+
 	// When this graph was last updated.
 	LastScan time.Time
 }
 
 func New() *Graph {
 	return &Graph{
-		DependsOn:  make(map[string]map[string]bool),
-		UsedBy:     make(map[string]map[string]bool),
-		Package:    make(map[string]*Package),
-		Repository: make(map[string]*Repository),
+		DependsOn:      make(map[string]map[string]bool),
+		UsedBy:         make(map[string]map[string]bool),
+		XTestDependsOn: make(map[string]map[string]bool),
+		XTestUsedBy:    make(map[string]map[string]bool),
+		Package:        make(map[string]*Package),
+		Repository:     make(map[string]*Repository),
+		Shadowed:       make(map[string][]string),
 	}
 }
 
@@ -80,12 +94,52 @@ func (g *Graph) FindRepo(key string) (*Repository, error) {
 	return found, nil
 }
 
-func (g *Graph) traceDeps(repo *Repository, through map[string]map[string]bool) ([]*Repository, error) {
+// FindPackage attempts to find a package with the given key, in the same way
+// as FindRepo: the key can be a full import path, its last elements (such as
+// "graph" or "rx/graph"), or a unique substring of it.
+func (g *Graph) FindPackage(key string) (*Package, error) {
+	if pkg, ok := g.Package[key]; ok {
+		return pkg, nil
+	}
+
+	var found *Package
+	for path, pkg := range g.Package {
+		if path == key || strings.HasSuffix(path, "/"+key) {
+			if found != nil {
+				return nil, fmt.Errorf("non-unique package specifier %q", key)
+			}
+			found = pkg
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+
+	for path, pkg := range g.Package {
+		if strings.Contains(path, key) {
+			if found != nil {
+				return nil, fmt.Errorf("non-unique package specifier %q", key)
+			}
+			found = pkg
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("unknown package %q", key)
+	}
+	return found, nil
+}
+
+// traceDeps returns the repositories (other than repo) containing the
+// packages linked to the packages in repo by any of the given edge maps.  Each
+// repository is listed once, however many edges lead to it.
+func (g *Graph) traceDeps(repo *Repository, through ...map[string]map[string]bool) ([]*Repository, error) {
 	roots := map[string]bool{}
-	for _, ipath := range repo.Packages {
-		for dep := range through[ipath] {
-			if pkg, ok := g.Package[dep]; ok && pkg.RepoRoot != repo.Root {
-				roots[pkg.RepoRoot] = true
+	for _, edges := range through {
+		for _, ipath := range repo.Packages {
+			for dep := range edges[ipath] {
+				if pkg, ok := g.Package[dep]; ok && pkg.RepoRoot != repo.Root {
+					roots[pkg.RepoRoot] = true
+				}
 			}
 		}
 	}
@@ -112,16 +166,81 @@ func (g *Graph) RepoUsers(repo *Repository) ([]*Repository, error) {
 	return g.traceDeps(repo, g.UsedBy)
 }
 
+// RepoXTestDeps returns a list of the repositories which contain packages
+// imported by the external tests of packages in the given repository.
+func (g *Graph) RepoXTestDeps(repo *Repository) ([]*Repository, error) {
+	return g.traceDeps(repo, g.XTestDependsOn)
+}
+
+// RepoXTestUsers returns a list of the repositories which contain packages
+// whose external tests import packages in the given repository.
+func (g *Graph) RepoXTestUsers(repo *Repository) ([]*Repository, error) {
+	return g.traceDeps(repo, g.XTestUsedBy)
+}
+
+// RepoTestDeps returns a list of the repositories which contain packages
+// needed to build and test (including external tests) the packages in the
+// given repository.
+func (g *Graph) RepoTestDeps(repo *Repository) ([]*Repository, error) {
+	return g.traceDeps(repo, g.DependsOn, g.XTestDependsOn)
+}
+
+// RepoTestUsers returns a list of the repositories which contain packages
+// which depend on packages in the given repository, either directly or in
+// their external tests.
+func (g *Graph) RepoTestUsers(repo *Repository) ([]*Repository, error) {
+	return g.traceDeps(repo, g.UsedBy, g.XTestUsedBy)
+}
+
+// SortRepos returns the given repositories ordered such that each repository
+// comes after all of the repositories (in the list) upon which it depends.
+// Repositories with no ordering constraint between them, including those that
+// form a dependency cycle, are ordered by their root path.  Imports by external
+// tests are not considered, since they do not affect the build order.
+func (g *Graph) SortRepos(repos []*Repository) ([]*Repository, error) {
+	sorted, err := g.TopoSort(repos, ImportEdges)
+	if _, ok := err.(*CycleError); ok {
+		err = nil
+	}
+	return sorted, err
+}
+
 // addImport adds both directions of an import relationship to the graph.
 func (g *Graph) addImport(importer, importee string) {
-	if g.DependsOn[importer] == nil {
-		g.DependsOn[importer] = make(map[string]bool)
+	addEdge(g.DependsOn, g.UsedBy, importer, importee)
+}
+
+// addXTestImport adds both directions of an import by an external test.
+func (g *Graph) addXTestImport(importer, importee string) {
+	if g.XTestDependsOn == nil {
+		// Graphs saved before external tests were tracked
+		g.XTestDependsOn = make(map[string]map[string]bool)
+		g.XTestUsedBy = make(map[string]map[string]bool)
+	}
+	addEdge(g.XTestDependsOn, g.XTestUsedBy, importer, importee)
+}
+
+// addEdge records an edge from a to b in the forward and reverse edge maps.
+func addEdge(forward, reverse map[string]map[string]bool, a, b string) {
+	if forward[a] == nil {
+		forward[a] = make(map[string]bool)
+	}
+	forward[a][b] = true
+	if reverse[b] == nil {
+		reverse[b] = make(map[string]bool)
 	}
-	g.DependsOn[importer][importee] = true
-	if g.UsedBy[importee] == nil {
-		g.UsedBy[importee] = make(map[string]bool)
+	reverse[b][a] = true
+}
+
+// delEdges removes all edges from a in the forward and reverse edge maps.
+func delEdges(forward, reverse map[string]map[string]bool, a string) {
+	for b := range forward[a] {
+		delete(reverse[b], a)
+		if len(reverse[b]) == 0 {
+			delete(reverse, b)
+		}
 	}
-	g.UsedBy[importee][importer] = true
+	delete(forward, a)
 }
 
 // addPackage adds a package to the graph and links it up automatically.
@@ -132,19 +251,47 @@ func (g *Graph) addPackage(pkg *Package) {
 	rep := g.Repository[pkg.RepoRoot]
 	rep.Packages = append(rep.Packages, pkg.ImportPath)
 
-	// TODO(kevlar): XTestImports? These can introduce circular deps...
 	for _, depList := range [][]string{pkg.Imports, pkg.TestImports} {
 		for _, dep := range depList {
 			g.addImport(pkg.ImportPath, dep)
 		}
 	}
+
+	// External tests almost always import their own package, which is not
+	// a dependency worth recording.
+	for _, dep := range pkg.XTestImports {
+		if dep != pkg.ImportPath {
+			g.addXTestImport(pkg.ImportPath, dep)
+		}
+	}
 }
 
 // delPackage removes references to a Package from the graph.
 // It should only be used when also removing the repository
-// or when the package will be re-added.
+// or when the package will be re-added.  Imports of the package by other
+// packages are left alone, since they still exist.
 func (g *Graph) delPackage(importPath string) {
 	delete(g.Package, importPath)
+	delEdges(g.DependsOn, g.UsedBy, importPath)
+	delEdges(g.XTestDependsOn, g.XTestUsedBy, importPath)
+}
+
+// removePackage removes a package from the graph and from its repository.
+// The repository is left in place, even if it is empty.
+func (g *Graph) removePackage(importPath string) {
+	pkg, ok := g.Package[importPath]
+	if !ok {
+		return
+	}
+	if rep, ok := g.Repository[pkg.RepoRoot]; ok {
+		for i, path := range rep.Packages {
+			if path == importPath {
+				rep.Packages = append(rep.Packages[:i], rep.Packages[i+1:]...)
+				break
+			}
+		}
+	}
+	g.delPackage(importPath)
 }
 
 // addRepository adds a Repository to the graph.
@@ -0,0 +1,105 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// roots returns the sorted roots of the given repositories.
+func roots(repos []*Repository) []string {
+	var r []string
+	for _, repo := range repos {
+		r = append(r, repo.Root)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func TestXTestImports(t *testing.T) {
+	g := New()
+	for _, root := range []string{"a", "b", "c"} {
+		g.addRepository(root, "git")
+	}
+
+	// a's external tests import b, which imports a: a cycle only through tests
+	g.addPackage(&Package{
+		ImportPath:   "a",
+		RepoRoot:     "a",
+		XTestGoFiles: []string{"a_test.go"},
+		XTestImports: []string{"a", "b", "testing"},
+	})
+	g.addPackage(&Package{ImportPath: "b", RepoRoot: "b", Imports: []string{"a"}})
+	g.addPackage(&Package{ImportPath: "c", RepoRoot: "c", TestImports: []string{"b"}})
+
+	if !g.Package["a"].IsTestable() {
+		t.Errorf("package with only external tests is not testable")
+	}
+	if g.Package["b"].IsTestable() {
+		t.Errorf("package without tests is testable")
+	}
+	if g.XTestDependsOn["a"]["a"] {
+		t.Errorf("external test import of the package itself was recorded")
+	}
+	if g.DependsOn["a"]["b"] {
+		t.Errorf("external test import was recorded as a regular import")
+	}
+
+	tests := []struct {
+		Desc  string
+		Trace func(*Repository) ([]*Repository, error)
+		Repo  string
+		Want  []string
+	}{
+		{"deps(a)", g.RepoDeps, "a", nil},
+		{"xtestdeps(a)", g.RepoXTestDeps, "a", []string{"b"}},
+		{"testdeps(a)", g.RepoTestDeps, "a", []string{"b"}},
+		{"users(b)", g.RepoUsers, "b", []string{"c"}},
+		{"xtestusers(b)", g.RepoXTestUsers, "b", []string{"a"}},
+		{"testusers(b)", g.RepoTestUsers, "b", []string{"a", "c"}},
+		{"testusers(a)", g.RepoTestUsers, "a", []string{"b"}},
+	}
+	for _, test := range tests {
+		repos, err := test.Trace(g.Repository[test.Repo])
+		if err != nil {
+			t.Errorf("%s: %s", test.Desc, err)
+			continue
+		}
+		if got := roots(repos); !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%s = %q, want %q", test.Desc, got, test.Want)
+		}
+	}
+
+	// A test-only cycle must not affect the build order: x imports y, so y
+	// comes first even though y's external tests import x.
+	g.addRepository("x", "git")
+	g.addRepository("y", "git")
+	g.addPackage(&Package{ImportPath: "x", RepoRoot: "x", Imports: []string{"y"}})
+	g.addPackage(&Package{ImportPath: "y", RepoRoot: "y", XTestImports: []string{"x", "y"}})
+	sorted, err := g.SortRepos([]*Repository{g.Repository["x"], g.Repository["y"]})
+	if err != nil {
+		t.Fatalf("sort: %s", err)
+	}
+	if got, want := []string{sorted[0].Root, sorted[1].Root}, []string{"y", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %q, want %q", got, want)
+	}
+
+	g.delPackage("a")
+	if len(g.XTestUsedBy["b"]) != 0 {
+		t.Errorf("external test imports of deleted package remain: %v", g.XTestUsedBy["b"])
+	}
+}
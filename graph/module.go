@@ -0,0 +1,336 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Module describes the go.mod file at the root of a repository.
+type Module struct {
+	Path    string        // The module path
+	Go      string        // The Go version from the go directive, if any
+	Require []Requirement // The required modules, in the order listed
+}
+
+// A Requirement is a module version required by a go.mod file.  If the
+// requirement is replaced, Path and Version name the replacement (Version is
+// empty when it is replaced by a local directory).
+type Requirement struct {
+	Path     string
+	Version  string
+	Indirect bool   // Marked "// indirect"
+	Replaced bool   // Replaced by a replace directive
+	Sum      string // The hash of the module's contents from go.sum, if known
+}
+
+// ReadModule reads the go.mod file (and go.sum, if present) in dir.  If there
+// is no go.mod, a nil Module and nil error are returned.
+func ReadModule(dir string) (*Module, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("graph: %s", err)
+	}
+	m, err := ParseModFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "go.sum"))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("graph: %s", err)
+	}
+	sums := ParseSums(data)
+	for i, req := range m.Require {
+		m.Require[i].Sum = sums[req.Path+" "+req.Version]
+	}
+	return m, nil
+}
+
+// ParseModFile parses the contents of a go.mod file.  Only the module, go,
+// require and replace directives are interpreted; others are ignored.
+func ParseModFile(data []byte) (*Module, error) {
+	m := new(Module)
+	replace := map[string]Requirement{} // "path" or "path version" -> replacement
+
+	var block string
+	for i, line := range strings.Split(string(data), "\n") {
+		var comment string
+		if c := strings.Index(line, "//"); c >= 0 {
+			line, comment = line[:c], strings.TrimSpace(line[c+2:])
+		}
+		fields, err := modFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("graph: go.mod:%d: %s", i+1, err)
+		}
+
+		var verb string
+		switch {
+		case len(fields) == 0:
+			continue
+		case block != "" && len(fields) == 1 && fields[0] == ")":
+			block = ""
+			continue
+		case block != "":
+			verb = block
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		default:
+			verb, fields = fields[0], fields[1:]
+		}
+
+		bad := func(what string) error {
+			return fmt.Errorf("graph: go.mod:%d: malformed %s", i+1, what)
+		}
+		switch verb {
+		case "module":
+			if len(fields) != 1 {
+				return nil, bad("module")
+			}
+			m.Path = fields[0]
+		case "go":
+			if len(fields) != 1 {
+				return nil, bad("go")
+			}
+			m.Go = fields[0]
+		case "require":
+			if len(fields) != 2 {
+				return nil, bad("require")
+			}
+			m.Require = append(m.Require, Requirement{
+				Path:     fields[0],
+				Version:  fields[1],
+				Indirect: comment == "indirect" || strings.HasPrefix(comment, "indirect;"),
+			})
+		case "replace":
+			// old [version] => new [version]
+			arrow := -1
+			for j, f := range fields {
+				if f == "=>" {
+					arrow = j
+				}
+			}
+			if arrow < 1 || arrow > 2 || len(fields)-arrow < 2 || len(fields)-arrow > 3 {
+				return nil, bad("replace")
+			}
+			old := strings.Join(fields[:arrow], " ")
+			with := Requirement{Path: fields[arrow+1], Replaced: true}
+			if len(fields)-arrow == 3 {
+				with.Version = fields[arrow+2]
+			}
+			replace[old] = with
+		}
+	}
+	if block != "" {
+		return nil, fmt.Errorf("graph: go.mod: unterminated %s block", block)
+	}
+	if m.Path == "" {
+		return nil, fmt.Errorf("graph: go.mod: no module directive")
+	}
+
+	for i, req := range m.Require {
+		with, ok := replace[req.Path+" "+req.Version]
+		if !ok {
+			with, ok = replace[req.Path]
+		}
+		if ok {
+			with.Indirect = req.Indirect
+			m.Require[i] = with
+		}
+	}
+	return m, nil
+}
+
+// modFields splits a line of a go.mod file into tokens.  Parentheses are
+// tokens by themselves, and quoted strings are unquoted.
+func modFields(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			fields = append(fields, line[i:i+1])
+			i++
+		case c == '"' || c == '`':
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				// Backslash escapes are only possible in double-quoted strings
+				if c == '"' && line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			quoted := line[i : end+1]
+			s, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", quoted)
+			}
+			fields = append(fields, s)
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r()\"`", rune(line[end])) {
+				end++
+			}
+			fields = append(fields, line[i:end])
+			i = end
+		}
+	}
+	return fields, nil
+}
+
+// ParseSums parses the contents of a go.sum file into a map from "path
+// version" to the hash of that module version.  The hashes of go.mod files
+// alone are not included.
+func ParseSums(data []byte) map[string]string {
+	sums := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[fields[0]+" "+fields[1]] = fields[2]
+	}
+	return sums
+}
+
+// loadModule reads the repository's go.mod file, if it has one.
+func (r *Repository) loadModule() error {
+	m, err := ReadModule(r.Root)
+	if err != nil {
+		return err
+	}
+	r.Module = m
+	return nil
+}
+
+// ModulePath returns the module path of the repository: the path from its
+// go.mod if it has one, or otherwise the import path corresponding to its root
+// directory.  The empty string is returned if neither is known.
+func (g *Graph) ModulePath(repo *Repository) string {
+	if repo.Module != nil {
+		return repo.Module.Path
+	}
+	for _, path := range repo.Packages {
+		pkg, ok := g.Package[path]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(repo.Root, pkg.Dir)
+		if err != nil {
+			continue
+		}
+		if rel == "." {
+			return path
+		}
+		if rel = filepath.ToSlash(rel); strings.HasSuffix(path, "/"+rel) {
+			return strings.TrimSuffix(path, "/"+rel)
+		}
+	}
+	return ""
+}
+
+// A Mismatch is a requirement on a repository's module which is not
+// satisfied by the revision checked out.
+type Mismatch struct {
+	User    *Repository // The repository whose go.mod has the requirement
+	Path    string      // The required module path
+	Version string      // The required version
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s requires %s %s", m.User, m.Path, m.Version)
+}
+
+// pseudoVersion matches pseudo-versions and captures their revision prefix.
+var pseudoVersion = regexp.MustCompile(`[-.]\d{14}-([0-9a-f]{12})$`)
+
+// Mismatches returns the requirements on repo's module by the go.mod files of
+// other repositories in the graph which its checked-out revision does not
+// satisfy.  A requirement is satisfied if the revision is tagged with the
+// required version, or is the revision named in a pseudo-version.
+func (g *Graph) Mismatches(repo *Repository) ([]Mismatch, error) {
+	path := g.ModulePath(repo)
+	if path == "" {
+		return nil, nil
+	}
+
+	var reqs []Mismatch
+	for _, user := range g.Repository {
+		if user == repo || user.Module == nil {
+			continue
+		}
+		for _, req := range user.Module.Require {
+			if req.Path == path && req.Version != "" {
+				reqs = append(reqs, Mismatch{user, req.Path, req.Version})
+			}
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	sort.Sort(byUser(reqs))
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := repo.Downgrades()
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bool{}
+	for _, tag := range tags {
+		if tag.Rev == head {
+			current[tag.Name] = true
+		}
+	}
+
+	var mismatches []Mismatch
+	for _, req := range reqs {
+		version := strings.TrimSuffix(req.Version, "+incompatible")
+		if m := pseudoVersion.FindStringSubmatch(version); m != nil {
+			if strings.HasPrefix(head, m[1]) {
+				continue
+			}
+		} else if current[version] {
+			continue
+		}
+		mismatches = append(mismatches, req)
+	}
+	return mismatches, nil
+}
+
+type byUser []Mismatch
+
+func (b byUser) Len() int           { return len(b) }
+func (b byUser) Less(i, j int) bool { return b[i].User.Root < b[j].User.Root }
+func (b byUser) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,218 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModFile(t *testing.T) {
+	tests := []struct {
+		Desc    string
+		ModFile string
+		Module  *Module
+		Error   string
+	}{
+		{
+			Desc: "full",
+			ModFile: `// A comment
+module example.com/a
+
+go 1.12
+
+require example.com/b v1.2.3
+require (
+	example.com/c v0.0.0-20130313010203-0123456789ab // indirect
+	"example.com/d" v2.0.0+incompatible
+	example.com/e v1.0.0
+	example.com/f v1.0.0
+)
+
+exclude example.com/b v1.2.2
+
+replace example.com/e => ../e
+replace (
+	example.com/f v1.0.0 => example.com/g v1.1.0
+	example.com/x v1.0.0 => example.com/y v1.0.0
+)
+`,
+			Module: &Module{
+				Path: "example.com/a",
+				Go:   "1.12",
+				Require: []Requirement{
+					{Path: "example.com/b", Version: "v1.2.3"},
+					{Path: "example.com/c", Version: "v0.0.0-20130313010203-0123456789ab", Indirect: true},
+					{Path: "example.com/d", Version: "v2.0.0+incompatible"},
+					{Path: "../e", Replaced: true},
+					{Path: "example.com/g", Version: "v1.1.0", Replaced: true},
+				},
+			},
+		},
+		{
+			Desc:    "quoted module",
+			ModFile: "module \"example.com/q\"\n",
+			Module:  &Module{Path: "example.com/q"},
+		},
+		{
+			Desc:    "no module",
+			ModFile: "go 1.12\n",
+			Error:   "no module",
+		},
+		{
+			Desc:    "bad require",
+			ModFile: "module a\nrequire b\n",
+			Error:   "go.mod:2: malformed require",
+		},
+		{
+			Desc:    "bad replace",
+			ModFile: "module a\nreplace b =>\n",
+			Error:   "malformed replace",
+		},
+		{
+			Desc:    "unterminated block",
+			ModFile: "module a\nrequire (\n\tb v1.0.0\n",
+			Error:   "unterminated require",
+		},
+		{
+			Desc:    "unterminated string",
+			ModFile: "module \"a\n",
+			Error:   "unterminated string",
+		},
+	}
+
+	for _, test := range tests {
+		m, err := ParseModFile([]byte(test.ModFile))
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: error = %v, want %q", test.Desc, err, test.Error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.Desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, test.Module) {
+			t.Errorf("%s: module = %+v, want %+v", test.Desc, m, test.Module)
+		}
+	}
+}
+
+func TestReadModuleSums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rx-module-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if m, err := ReadModule(dir); m != nil || err != nil {
+		t.Errorf("ReadModule without go.mod = %v, %v; want nil, nil", m, err)
+	}
+
+	writeTree(t, dir, map[string]string{
+		"go.mod": "module example.com/a\n\nrequire example.com/b v1.0.0\n",
+		"go.sum": "example.com/b v1.0.0 h1:contents=\nexample.com/b v1.0.0/go.mod h1:gomod=\n",
+	})
+	m, err := ReadModule(dir)
+	if err != nil {
+		t.Fatalf("ReadModule: %s", err)
+	}
+	if got, want := m.Require[0].Sum, "h1:contents="; got != want {
+		t.Errorf("sum = %q, want %q", got, want)
+	}
+}
+
+func TestMismatches(t *testing.T) {
+	gopath, done := tempGOPATH(t)
+	defer done()
+
+	src := filepath.Join(gopath, "src")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=rx", "GIT_AUTHOR_EMAIL=rx@localhost",
+			"GIT_COMMITTER_NAME=rx", "GIT_COMMITTER_EMAIL=rx@localhost",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	// The library has two tagged versions and is checked out at v1.1.0
+	lib := filepath.Join(src, "example.com", "lib")
+	writeTree(t, lib, map[string]string{"lib.go": "package lib\n"})
+	gitInit(t, lib)
+	git(lib, "add", ".")
+	git(lib, "commit", "-q", "-m", "one")
+	git(lib, "tag", "v1.0.0")
+	v100 := git(lib, "rev-parse", "HEAD")
+	writeTree(t, lib, map[string]string{"more.go": "package lib\n"})
+	git(lib, "add", ".")
+	git(lib, "commit", "-q", "-m", "two")
+	git(lib, "tag", "v1.1.0")
+	v110 := git(lib, "rev-parse", "HEAD")
+
+	users := map[string]string{
+		"old":    "v1.0.0",
+		"same":   "v1.1.0",
+		"pseudo": "v0.0.0-20130313010203-" + v110[:12],
+		"stale":  "v1.0.1-0.20130313010203-" + v100[:12],
+	}
+	for name, version := range users {
+		dir := filepath.Join(src, "example.com", name)
+		writeTree(t, dir, map[string]string{
+			name + ".go": "package " + name + "\n\nimport _ \"example.com/lib\"\n",
+			"go.mod":     "module example.com/" + name + "\n\nrequire example.com/lib " + version + "\n",
+		})
+		gitInit(t, dir)
+	}
+
+	g := New()
+	if err := g.Scan("example.com/..."); err != nil {
+		t.Fatalf("scan: %s", err)
+	}
+	repo, err := g.FindRepo("lib")
+	if err != nil {
+		t.Fatalf("find lib: %s", err)
+	}
+	if got, want := g.ModulePath(repo), "example.com/lib"; got != want {
+		t.Errorf("module path = %q, want %q", got, want)
+	}
+
+	mismatches, err := g.Mismatches(repo)
+	if err != nil {
+		t.Fatalf("mismatches: %s", err)
+	}
+	var got []string
+	for _, m := range mismatches {
+		got = append(got, m.String())
+	}
+	want := []string{
+		"example.com/old requires example.com/lib v1.0.0",
+		"example.com/stale requires example.com/lib " + users["stale"],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatches = %q, want %q", got, want)
+	}
+}
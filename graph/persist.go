@@ -0,0 +1,143 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileVersion is the version of the format written by Write.  It must be
+// incremented whenever a change to the graph would make graphs written by
+// earlier versions wrong (not just incomplete) when read, and a migration
+// from the previous version must be added to migrations.
+//
+// Version history:
+//
+//	1: the initial format
+//	2: external test imports, go.mod files and shadowed packages are recorded
+const FileVersion = 2
+
+// migrations[v] upgrades a graph read from a file of version v to version v+1.
+var migrations = map[int]func(*Graph) error{
+	1: func(g *Graph) error {
+		// Version 1 graphs have no external test imports, modules or
+		// shadowed packages, so every package needs to be relisted.  Since
+		// everything has changed since the zero time, an incremental scan
+		// will do so without starting over.
+		g.LastScan = time.Time{}
+		return nil
+	},
+}
+
+// A VersionError is returned when reading a graph written by a newer version
+// of rx, which this version does not know how to read.
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("graph file version %d is newer than the latest supported version %d", e.Version, FileVersion)
+}
+
+// Read decodes a graph written by Write, migrating it from an earlier version
+// if necessary.
+func Read(r io.Reader) (*Graph, error) {
+	dec := gob.NewDecoder(r)
+
+	var version int
+	if err := dec.Decode(&version); err != nil {
+		return nil, fmt.Errorf("graph: bad file version: %s", err)
+	}
+	if version > FileVersion {
+		return nil, &VersionError{version}
+	}
+	if version < 1 {
+		return nil, fmt.Errorf("graph: bad file version %d", version)
+	}
+
+	// Decoding into a new graph keeps its maps, even if they were empty
+	// (and thus left out) when the graph was written.
+	g := New()
+	if err := dec.Decode(g); err != nil {
+		return nil, fmt.Errorf("graph: decoding version %d graph: %s", version, err)
+	}
+	for ; version < FileVersion; version++ {
+		if err := migrations[version](g); err != nil {
+			return nil, fmt.Errorf("graph: migrating from version %d: %s", version, err)
+		}
+	}
+	return g, nil
+}
+
+// Write encodes the graph, preceded by its FileVersion.
+func (g *Graph) Write(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(FileVersion); err != nil {
+		return fmt.Errorf("graph: writing version: %s", err)
+	}
+	if err := enc.Encode(g); err != nil {
+		return fmt.Errorf("graph: encoding graph: %s", err)
+	}
+	return nil
+}
+
+// ReadFile reads a graph from the named file with Read.
+func ReadFile(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// WriteFile writes the graph to the named file with Write.  The graph is
+// written to a temporary file in the same directory which then replaces the
+// named file, so that the file is never left partially written.
+func (g *Graph) WriteFile(path string) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("graph: %s", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("graph: %s", err)
+	}
+	if err := g.Write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("graph: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("graph: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("graph: %s", err)
+	}
+	return nil
+}
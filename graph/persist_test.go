@@ -0,0 +1,87 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadWrite(t *testing.T) {
+	g := New()
+	g.LastScan = time.Date(2013, 3, 13, 1, 2, 3, 0, time.UTC)
+	g.addRepository("r", "git")
+	g.addPackage(&Package{ImportPath: "r", RepoRoot: "r", Imports: []string{"fmt"}})
+
+	dir, err := ioutil.TempDir("", "rx-persist-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "graph")
+
+	if err := g.WriteFile(path); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if err := g.WriteFile(path); err != nil {
+		t.Fatalf("overwrite: %s", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("temporary files were left behind: %d files", len(files))
+	}
+
+	read, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if !read.LastScan.Equal(g.LastScan) || !read.DependsOn["r"]["fmt"] {
+		t.Errorf("read graph does not match: %+v", read)
+	}
+	// Maps which were empty when written must still be usable
+	read.addXTestImport("r", "testing")
+	read.Shadowed["r"] = nil
+
+	// A version 1 file is migrated so that everything gets relisted
+	buf := new(bytes.Buffer)
+	enc := gob.NewEncoder(buf)
+	enc.Encode(1)
+	enc.Encode(g)
+	old, err := Read(buf)
+	if err != nil {
+		t.Fatalf("read version 1: %s", err)
+	}
+	if !old.LastScan.IsZero() || !old.DependsOn["r"]["fmt"] {
+		t.Errorf("version 1 graph was not migrated: %+v", old)
+	}
+
+	// Newer versions are refused
+	buf.Reset()
+	gob.NewEncoder(buf).Encode(FileVersion + 1)
+	if _, err := Read(buf); err == nil {
+		t.Errorf("reading a newer version succeeded")
+	} else if _, ok := err.(*VersionError); !ok {
+		t.Errorf("reading a newer version: error = %v, want a *VersionError", err)
+	}
+
+	// Garbage is an error, not a panic
+	if _, err := Read(bytes.NewBufferString("not a graph")); err == nil {
+		t.Errorf("reading garbage succeeded")
+	}
+}
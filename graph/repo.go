@@ -15,12 +15,7 @@
 package graph
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
-	"regexp"
-	"strings"
-	"text/template"
 
 	"kylelemons.net/go/rx/vcs"
 )
@@ -30,6 +25,7 @@ type Repository struct {
 	Root     string   // directory containing the repository
 	VCS      string   // version control system
 	Packages []string // packages contained in this repository
+	Module   *Module  // the go.mod file at the root, if any
 }
 
 // String returns the import pattern matching all packages
@@ -57,98 +53,255 @@ func (r *Repository) String() string {
 	return first[:prefix] + "..."
 }
 
+// driver returns the version control driver for the repository.
+func (r *Repository) driver() (vcs.Driver, error) {
+	d, err := vcs.Lookup(r.VCS)
+	if err != nil {
+		return nil, fmt.Errorf("repo: %s", err)
+	}
+	return d, nil
+}
+
+// Head returns the absolute identifier of the current revision.
 func (r *Repository) Head() (string, error) {
-	tool, ok := vcs.Known[r.VCS]
-	if !ok {
-		return "", fmt.Errorf("repo: unknown vcs %q", r.VCS)
+	d, err := r.driver()
+	if err != nil {
+		return "", err
 	}
-	cmd := exec.Command(tool.Command, tool.Current...)
-	cmd.Dir = r.Root
-	out, err := cmd.Output()
+	head, err := d.Head(r.Root)
 	if err != nil {
 		return "", fmt.Errorf("repo: head: %s", err)
 	}
-	return strings.TrimSpace(string(out)), nil
+	return head, nil
 }
 
+// ToRev updates the working copy to the given revision.
 func (r *Repository) ToRev(rev string) error {
-	// TODO(kevlar): This is getting repetitive...
-	tool, ok := vcs.Known[r.VCS]
+	d, err := r.driver()
+	if err != nil {
+		return err
+	}
+	if err := d.Checkout(r.Root, rev); err != nil {
+		return fmt.Errorf("repo: to rev %q: %s", rev, err)
+	}
+	return nil
+}
+
+// Branch returns the branch the working copy is attached to, or "" if it is
+// detached or the version control system has no movable branches.
+func (r *Repository) Branch() (string, error) {
+	d, err := r.driver()
+	if err != nil {
+		return "", err
+	}
+	b, ok := d.(vcs.Brancher)
 	if !ok {
-		return fmt.Errorf("repo: unknown vcs %q", r.VCS)
+		return "", nil
 	}
-	cmd := exec.Command(tool.Command)
-	cmd.Dir = r.Root
-	for _, arg := range tool.ToRev {
-		cmd.Args = append(cmd.Args, tsub(arg, rev))
+	branch, err := b.Branch(r.Root)
+	if err != nil {
+		return "", fmt.Errorf("repo: branch: %s", err)
 	}
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("repo: to rev %q: %s", rev, err)
+	return branch, nil
+}
+
+// A BranchMovedError is returned by ToBranch when the branch no longer points
+// to the requested revision.
+type BranchMovedError struct {
+	Branch string // The branch which was to be checked out
+	Rev    string // The revision the working copy is at
+	Now    string // The revision the branch points to
+}
+
+func (e *BranchMovedError) Error() string {
+	return fmt.Sprintf("repo: branch %q has moved from %s to %s", e.Branch, e.Rev, e.Now)
+}
+
+// ToBranch updates the working copy to rev and attaches it to the named
+// branch.  If the branch has since moved away from rev, the working copy is
+// left detached at rev and a *BranchMovedError is returned, unless reset is
+// true, in which case the branch is moved back to rev.  A missing branch is
+// recreated.  If branch is empty, this is the same as ToRev.
+func (r *Repository) ToBranch(branch, rev string, reset bool) error {
+	d, err := r.driver()
+	if err != nil {
+		return err
+	}
+	b, ok := d.(vcs.Brancher)
+	if branch == "" || !ok {
+		return r.ToRev(rev)
+	}
+	if now, err := b.BranchRev(r.Root, branch); err == nil && now != rev && !reset {
+		if err := r.ToRev(rev); err != nil {
+			return err
+		}
+		return &BranchMovedError{branch, rev, now}
+	}
+	if err := b.SetBranch(r.Root, branch, rev); err != nil {
+		return fmt.Errorf("repo: to branch %q at %q: %s", branch, rev, err)
 	}
 	return nil
 }
 
-func (r *Repository) Tags() (TagList, error) {
-	tool, ok := vcs.Known[r.VCS]
+// Fetch pulls new revisions and tags from the repository's default remote.
+// The working copy is not modified.  If the version control system can't do
+// this, vcs.ErrUnsupported is returned.
+func (r *Repository) Fetch() error {
+	d, err := r.driver()
+	if err != nil {
+		return err
+	}
+	if err := d.Fetch(r.Root); err == vcs.ErrUnsupported {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("repo: fetch: %s", err)
+	}
+	return nil
+}
+
+// Dirty returns true if the working copy has uncommitted changes.  If the
+// version control system can't tell, vcs.ErrUnsupported is returned.
+func (r *Repository) Dirty() (bool, error) {
+	d, err := r.driver()
+	if err != nil {
+		return false, err
+	}
+	dirty, err := d.Dirty(r.Root)
+	if err == vcs.ErrUnsupported {
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("repo: dirty: %s", err)
+	}
+	return dirty, nil
+}
+
+// stasher returns the repository's driver if it supports stashing.
+func (r *Repository) stasher() (vcs.Stasher, error) {
+	d, err := r.driver()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := d.(vcs.Stasher)
 	if !ok {
-		return nil, fmt.Errorf("repo: unknown vcs %q", r.VCS)
+		return nil, fmt.Errorf("repo: %s does not support stashing changes", r.VCS)
+	}
+	return s, nil
+}
+
+// Stash sets aside uncommitted changes in the working copy.
+func (r *Repository) Stash() error {
+	s, err := r.stasher()
+	if err != nil {
+		return err
 	}
-	up, err := r.revTags(tool, tool.HeadRev, tool.Updates, tool.UpdatesRegex)
+	if err := s.Stash(r.Root); err != nil {
+		return fmt.Errorf("repo: stash: %s", err)
+	}
+	return nil
+}
+
+// Unstash reapplies the changes set aside by the last Stash.
+func (r *Repository) Unstash() error {
+	s, err := r.stasher()
+	if err != nil {
+		return err
+	}
+	if err := s.Unstash(r.Root); err != nil {
+		return fmt.Errorf("repo: unstash: %s", err)
+	}
+	return nil
+}
+
+// Tags returns the upgrades followed by the downgrades.
+func (r *Repository) Tags() (TagList, error) {
+	up, err := r.Upgrades()
 	if err != nil {
 		return nil, err
 	}
-	down, err := r.revTags(tool, tool.HeadRev, tool.TagList, tool.TagListRegex)
+	down, err := r.Downgrades()
 	if err != nil {
 		return nil, err
 	}
 	return append(up, down...), nil
 }
 
+// Upgrades returns the tags for which the current revision is an ancestor.
 func (r *Repository) Upgrades() (TagList, error) {
-	tool, ok := vcs.Known[r.VCS]
-	if !ok {
-		return nil, fmt.Errorf("repo: unknown vcs %q", r.VCS)
+	d, err := r.driver()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := d.Updates(r.Root)
+	if err != nil {
+		return nil, fmt.Errorf("repo: list tags: %s", err)
 	}
-	return r.revTags(tool, tool.HeadRev, tool.Updates, tool.UpdatesRegex)
+	return tagList(tags), nil
 }
 
+// Downgrades returns the tags which are ancestors of the current revision.
 func (r *Repository) Downgrades() (TagList, error) {
-	tool, ok := vcs.Known[r.VCS]
+	d, err := r.driver()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := d.Tags(r.Root)
+	if err != nil {
+		return nil, fmt.Errorf("repo: list tags: %s", err)
+	}
+	return tagList(tags), nil
+}
+
+// Count returns the number of commits which are in rev to but not in rev from.
+func (r *Repository) Count(from, to string) (int, error) {
+	d, err := r.driver()
+	if err != nil {
+		return 0, err
+	}
+	c, ok := d.(vcs.Counter)
 	if !ok {
-		return nil, fmt.Errorf("repo: unknown vcs %q", r.VCS)
+		return 0, fmt.Errorf("repo: %s does not support counting commits", r.VCS)
+	}
+	n, err := c.Count(r.Root, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("repo: count: %s", err)
 	}
-	return r.revTags(tool, tool.HeadRev, tool.TagList, tool.TagListRegex)
+	return n, nil
 }
 
-func (r *Repository) revTags(tool *vcs.Tool, rev string, command []string, regex string) (TagList, error) {
-	cmd := exec.Command(tool.Command)
-	cmd.Dir = r.Root
-	for _, arg := range command {
-		cmd.Args = append(cmd.Args, tsub(arg, rev))
+// HasRev returns true if the given revision is present in the repository.
+func (r *Repository) HasRev(rev string) (bool, error) {
+	d, err := r.driver()
+	if err != nil {
+		return false, err
+	}
+	rc, ok := d.(vcs.RevChecker)
+	if !ok {
+		return false, fmt.Errorf("repo: %s does not support checking for revisions", r.VCS)
 	}
-	out, err := cmd.Output()
+	has, err := rc.HasRev(r.Root, rev)
 	if err != nil {
-		return nil, fmt.Errorf("repo: list tags: %s", err)
+		return false, fmt.Errorf("repo: has rev: %s", err)
 	}
-	reg := regexp.MustCompile(regex)
-	word := regexp.MustCompile(`[^, ]+`)
-	var tags TagList
-	for _, line := range strings.Split(string(out), "\n") {
-		if len(line) == 0 {
-			continue
-		}
-		match := reg.FindStringSubmatch(line)
-		if match == nil {
-			continue
-		}
-		for _, tagName := range word.FindAllString(match[2], -1) {
-			tags = append(tags, Tag{
-				Name: tagName,
-				Rev:  match[1],
-			})
-		}
+	return has, nil
+}
+
+// TagsBetween returns the tags (but not branches) which are in rev to but not
+// in rev from, newest first.
+func (r *Repository) TagsBetween(from, to string) (TagList, error) {
+	d, err := r.driver()
+	if err != nil {
+		return nil, err
+	}
+	tl, ok := d.(vcs.TagLister)
+	if !ok {
+		return nil, fmt.Errorf("repo: %s does not support listing tags between revisions", r.VCS)
+	}
+	tags, err := tl.TagsBetween(r.Root, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("repo: tags between: %s", err)
 	}
-	return tags, nil
+	return tagList(tags), nil
 }
 
 // Package is a subset of cmd/go.Package
@@ -167,12 +320,12 @@ type Package struct {
 	// Package files
 	GoFiles      []string // .go files
 	TestGoFiles  []string // _test.go files
-	XTestGoFiles []string // currently ignored
+	XTestGoFiles []string // _test.go files outside package (package x_test)
 
 	// Package imports
 	Imports      []string // import paths used by this package
 	TestImports  []string // import paths used by _test.go files in this package
-	XTestImports []string // currently ignored
+	XTestImports []string // import paths used by XTestGoFiles
 
 	// Rx specific
 	RepoRoot string
@@ -192,10 +345,10 @@ func (p *Package) IsBinary() bool {
 }
 
 // IsTestable returns true if the package is testable.
-// A package is testable iff there are one or more _test.go files.
+// A package is testable iff there are one or more _test.go files,
+// whether they are in the package itself or in an external test package.
 func (p *Package) IsTestable() bool {
-	// TODO(kevlar): XTestGoFiles ?
-	return len(p.TestGoFiles) > 0
+	return len(p.TestGoFiles) > 0 || len(p.XTestGoFiles) > 0
 }
 
 // DetectVCS attempts to detect which version control system is hosting the
@@ -203,25 +356,14 @@ func (p *Package) IsTestable() bool {
 // longer root path is chosen.  If more than one have identical length paths,
 // the result is undefined.
 func (p *Package) DetectVCS() (vcsFound, root string) {
-	for name, tool := range vcs.Known {
-		cmd := exec.Command(tool.Command, tool.RootDir...)
-		cmd.Dir = p.Dir
-		b, err := cmd.Output()
+	for _, d := range vcs.Drivers() {
+		dir, err := d.Root(p.Dir)
 		if err != nil {
 			continue
 		}
-		if len(b) > len(root) {
-			vcsFound, root = name, strings.TrimSpace(string(b))
+		if len(dir) > len(root) {
+			vcsFound, root = d.Name(), dir
 		}
 	}
 	return vcsFound, root
 }
-
-func tsub(tpl string, data interface{}) string {
-	b := new(bytes.Buffer)
-	t := template.New("help")
-	if err := template.Must(t.Parse(tpl)).Execute(b, data); err != nil {
-		panic(err)
-	}
-	return b.String()
-}
@@ -19,10 +19,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -39,48 +43,204 @@ func (g *Graph) Scan(target string) error {
 	// will have happened before LastScan.
 	g.LastScan = start
 
-	list := exec.Command("go", "list", "-e", "-json", target)
-	list.Stderr = os.Stderr
-	js, err := list.Output()
+	found, err := listPackages(target)
 	if err != nil {
-		return fmt.Errorf("repo: go list %q: %s", target, err)
+		return err
 	}
-	dec := json.NewDecoder(bytes.NewReader(js))
 
-	// A type for the completed processing
-	type found struct {
-		*Package
-		vcs string
+	seen := map[string]bool{}
+	for _, f := range preferListed(found) {
+		if g.shadowedBy(f.Package) {
+			continue
+		}
+		if !seen[f.RepoRoot] {
+			g.delRepository(f.RepoRoot)
+			g.addRepository(f.RepoRoot, f.vcs)
+		}
+		seen[f.RepoRoot] = true
+		g.addPackage(f.Package)
 	}
 
-	// Make a channel to send the completed packages on
-	pkgs := make(chan found, 32)
+	for root := range seen {
+		repo := g.Repository[root]
+		sort.Strings(repo.Packages)
+		if err := repo.loadModule(); err != nil {
+			log.Printf("repo: %s: %s", root, err)
+		}
+	}
+	g.findShadows()
+	return nil
+}
 
-	// Sync up on the completed processing
-	wg := sync.WaitGroup{}
+// ScanChanged updates the dependency graph with the packages in the GOPATH
+// which have changed since LastScan, without listing the packages which have
+// not.  A package has changed if its directory or any of its .go files have
+// been modified; new packages are found the same way.  Packages whose
+// directories have vanished are removed, as are repositories left empty.
+// Symbolic links within the GOPATH are not followed.
+func (g *Graph) ScanChanged() error {
+	start := time.Now()
+	defer func() {
+		log.Printf("Incremental scan took %s", time.Since(start))
+	}()
 
-	process := func(pkg *Package) {
-		defer wg.Done()
+	since := g.LastScan
+	g.LastScan = start
 
-		// Only save packages we want to keep
-		if !pkg.Keep() {
-			log.Printf("Skipping %q", pkg.ImportPath)
-			return
+	known := map[string]string{} // package directory -> import path
+	for path, pkg := range g.Package {
+		known[pkg.Dir] = path
+	}
+
+	var changed []string
+	for _, src := range srcDirs() {
+		err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if path == src && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if path != src && skipDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			dirChanged, hasGo, err := checkDir(path, fi, since)
+			if err != nil {
+				return err
+			}
+			if _, ok := known[path]; ok && (dirChanged || !hasGo) {
+				g.removePackage(known[path])
+			}
+			if dirChanged && hasGo {
+				changed = append(changed, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("repo: scanning %q: %s", src, err)
+		}
+	}
+
+	// Packages outside of the GOPATH, or whose directories have vanished
+	for dir, path := range known {
+		if _, err := os.Stat(dir); err != nil {
+			g.removePackage(path)
+		}
+	}
+
+	relisted := map[string]bool{}
+	if len(changed) > 0 {
+		log.Printf("Relisting %d changed packages", len(changed))
+		found, err := listPackages(changed...)
+		if err != nil {
+			return err
+		}
+		for _, f := range preferListed(found) {
+			// A changed package may have moved to another repository
+			if g.shadowedBy(f.Package) {
+				continue
+			}
+			g.removePackage(f.ImportPath)
+			g.addRepository(f.RepoRoot, f.vcs)
+			g.addPackage(f.Package)
+			sort.Strings(g.Repository[f.RepoRoot].Packages)
+			relisted[f.RepoRoot] = true
 		}
-		log.Printf("Adding %q", pkg.ImportPath)
+	}
+
+	for root, repo := range g.Repository {
+		if len(repo.Packages) == 0 {
+			delete(g.Repository, root)
+			continue
+		}
+		if relisted[root] || modChanged(root, repo.Module != nil, since) {
+			if err := repo.loadModule(); err != nil {
+				log.Printf("repo: %s: %s", root, err)
+			}
+		}
+	}
+	g.findShadows()
+	return nil
+}
+
+// modChanged returns true if the go.mod or go.sum file in dir has been
+// modified since the given time, or if go.mod has appeared or disappeared.
+func modChanged(dir string, had bool, since time.Time) bool {
+	for _, name := range []string{"go.mod", "go.sum"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if name == "go.mod" && (err == nil) != had {
+			return true
+		}
+		if err == nil && fi.ModTime().After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// srcDirs returns the src directories of the GOPATH.
+func srcDirs() []string {
+	var dirs []string
+	for _, entry := range GOPATH() {
+		dirs = append(dirs, filepath.Join(entry, "src"))
+	}
+	return dirs
+}
 
-		// Detect the version control system
-		vcs, root := pkg.DetectVCS()
-		pkg.RepoRoot = root
+// skipDir returns true for directories the go tool ignores.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata"
+}
 
-		// Ignore things we don't understand :D
-		if vcs == "" {
-			return
+// checkDir reports whether the directory or any .go file within it has been
+// modified after since, and whether it contains any .go files.
+func checkDir(dir string, fi os.FileInfo, since time.Time) (changed, hasGo bool, err error) {
+	changed = fi.ModTime().After(since)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, false, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+		hasGo = true
+		if e.ModTime().After(since) {
+			changed = true
 		}
+	}
+	return changed, hasGo, nil
+}
+
+// A Lister returns information about the packages matching the given
+// targets, each of which may be an import path, a pattern containing "...",
+// a directory, or "all".
+type Lister func(targets ...string) ([]*Package, error)
+
+// Listers holds the available Listers by name.
+var Listers = map[string]Lister{
+	"golist":  GoList,
+	"gobuild": BuildList,
+}
+
+// ListPackages is the Lister used by Scan and ScanChanged.
+var ListPackages Lister = GoList
 
-		pkgs <- found{pkg, vcs}
+// GoList is a Lister which runs "go list".
+func GoList(targets ...string) ([]*Package, error) {
+	args := append([]string{"list", "-e", "-json"}, targets...)
+	list := exec.Command("go", args...)
+	list.Stderr = os.Stderr
+	js, err := list.Output()
+	if err != nil {
+		return nil, fmt.Errorf("repo: go list %q: %s", targets, err)
 	}
+	dec := json.NewDecoder(bytes.NewReader(js))
 
+	var pkgs []*Package
 	for {
 		pkg := new(Package)
 
@@ -92,29 +252,74 @@ func (g *Graph) Scan(target string) error {
 			log.Printf("repo: error parsing package: %s", err)
 			break
 		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// A listed package is one which rx should keep, along with the version control
+// system of its repository.
+type listed struct {
+	*Package
+	vcs string
+}
+
+// listPackages lists the given targets with ListPackages and returns the
+// packages which rx should keep.
+func listPackages(targets ...string) ([]listed, error) {
+	all, err := ListPackages(targets...)
+	if err != nil {
+		return nil, err
+	}
 
+	// Make a channel to send the completed packages on
+	pkgs := make(chan listed, 32)
+
+	// Detect version control with a bounded number of workers
+	work := make(chan *Package)
+	wg := sync.WaitGroup{}
+
+	process := func() {
+		defer wg.Done()
+		for pkg := range work {
+			// Only save packages we want to keep
+			if !pkg.Keep() {
+				log.Printf("Skipping %q", pkg.ImportPath)
+				continue
+			}
+			log.Printf("Adding %q", pkg.ImportPath)
+
+			// Detect the version control system
+			vcs, root := pkg.DetectVCS()
+			pkg.RepoRoot = root
+
+			// Ignore things we don't understand :D
+			if vcs == "" {
+				continue
+			}
+
+			pkgs <- listed{pkg, vcs}
+		}
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
 		wg.Add(1)
-		go process(pkg)
+		go process()
 	}
 
-	// Wait on repo detection, then close
 	go func() {
+		for _, pkg := range all {
+			work <- pkg
+		}
+		close(work)
+
+		// Wait on repo detection, then close
 		wg.Wait()
 		close(pkgs)
 	}()
 
-	seen := map[string]bool{}
+	var found []listed
 	for f := range pkgs {
-		if !seen[f.RepoRoot] {
-			g.delRepository(f.RepoRoot)
-			g.addRepository(f.RepoRoot, f.vcs)
-		}
-		seen[f.RepoRoot] = true
-		g.addPackage(f.Package)
+		found = append(found, f)
 	}
-
-	for root := range seen {
-		sort.Strings(g.Repository[root].Packages)
-	}
-	return nil
+	return found, nil
 }
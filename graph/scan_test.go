@@ -0,0 +1,217 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeTree writes the given files, named relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+}
+
+// tempGOPATH makes a temporary GOPATH and uses it for the go command and
+// go/build, skipping the test if go or git are not available.  The returned
+// function removes it and restores the environment.
+func tempGOPATH(t *testing.T) (gopath string, done func()) {
+	for _, bin := range []string{"go", "git"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found: %s", bin, err)
+		}
+	}
+
+	gopath, err := ioutil.TempDir("", "rx-scan-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+
+	env := map[string]string{"GOPATH": gopath, "GO111MODULE": "off", "GOFLAGS": ""}
+	old := map[string]string{}
+	for key, value := range env {
+		old[key] = os.Getenv(key)
+		os.Setenv(key, value)
+	}
+	oldDefault, oldContext := build.Default.GOPATH, BuildContext.GOPATH
+	build.Default.GOPATH, BuildContext.GOPATH = gopath, gopath
+
+	return gopath, func() {
+		for key, value := range old {
+			os.Setenv(key, value)
+		}
+		build.Default.GOPATH, BuildContext.GOPATH = oldDefault, oldContext
+		os.RemoveAll(gopath)
+	}
+}
+
+// gitInit makes each of the given directories a git repository.
+func gitInit(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		cmd := exec.Command("git", "init", "-q")
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git init: %s\n%s", err, out)
+		}
+	}
+}
+
+func TestScanChanged(t *testing.T) {
+	gopath, done := tempGOPATH(t)
+	defer done()
+
+	src := filepath.Join(gopath, "src")
+	writeTree(t, src, map[string]string{
+		"example.com/a/a.go":       "package a\n\nimport _ \"example.com/a/b\"\n",
+		"example.com/a/b/b.go":     "package b\n",
+		"example.com/a/gone/g.go":  "package gone\n\nimport _ \"example.com/same\"\n",
+		"example.com/old/old.go":   "package old\n",
+		"example.com/same/same.go": "package same\n",
+	})
+	for _, repo := range []string{"a", "old", "same"} {
+		gitInit(t, filepath.Join(src, "example.com", repo))
+	}
+
+	g := New()
+	if err := g.Scan("example.com/..."); err != nil {
+		t.Fatalf("scan: %s", err)
+	}
+
+	// Back-date everything so that only the changes below are newer
+	past := time.Now().Add(-time.Hour)
+	filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err == nil {
+			os.Chtimes(path, past, past)
+		}
+		return nil
+	})
+	g.LastScan = past.Add(time.Minute)
+
+	// A stale package record which must be kept unless it is relisted
+	g.Package["example.com/same"].Name = "unchanged"
+
+	writeTree(t, src, map[string]string{
+		"example.com/a/b/b.go":   "package b\n\nimport _ \"example.com/a/c\"\n",
+		"example.com/a/c/c.go":   "package c\n",
+		"example.com/a/gone/g.x": "not go\n",
+	})
+	os.Remove(filepath.Join(src, "example.com", "a", "gone", "g.go"))
+	os.RemoveAll(filepath.Join(src, "example.com", "old"))
+
+	if err := g.ScanChanged(); err != nil {
+		t.Fatalf("scan changed: %s", err)
+	}
+
+	var pkgs []string
+	for path := range g.Package {
+		pkgs = append(pkgs, path)
+	}
+	sort.Strings(pkgs)
+	if want := []string{"example.com/a", "example.com/a/b", "example.com/a/c", "example.com/same"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("packages = %q, want %q", pkgs, want)
+	}
+
+	var repos []string
+	for _, repo := range g.Repository {
+		repos = append(repos, repo.String())
+	}
+	sort.Strings(repos)
+	if want := []string{"example.com/a...", "example.com/same"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("repos = %q, want %q", repos, want)
+	}
+
+	if !g.DependsOn["example.com/a/b"]["example.com/a/c"] || !g.UsedBy["example.com/a/c"]["example.com/a/b"] {
+		t.Errorf("new import of example.com/a/c by example.com/a/b is missing")
+	}
+	if !g.UsedBy["example.com/a/b"]["example.com/a"] {
+		t.Errorf("existing import of example.com/a/b by example.com/a was lost")
+	}
+	if g.UsedBy["example.com/same"]["example.com/a/gone"] {
+		t.Errorf("vanished package example.com/a/gone still imports example.com/same")
+	}
+	if got := g.Package["example.com/same"].Name; got != "unchanged" {
+		t.Errorf("unchanged package was relisted (name = %q)", got)
+	}
+}
+
+func TestBuildList(t *testing.T) {
+	gopath, done := tempGOPATH(t)
+	defer done()
+
+	src := filepath.Join(gopath, "src")
+	writeTree(t, src, map[string]string{
+		"example.com/a/a.go":                      "package a\n\nimport (\n\t\"fmt\"\n\t_ \"example.com/v\"\n)\n\nvar _ = fmt.Sprint\n",
+		"example.com/a/a_test.go":                 "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+		"example.com/a/x_test.go":                 "package a_test\n\nimport _ \"example.com/a\"\n",
+		"example.com/a/linux.go":                  "// +build linux\n\npackage a\n",
+		"example.com/a/tagged.go":                 "// +build rxtag\n\npackage a\n\nimport _ \"example.com/a/cmd\"\n",
+		"example.com/a/vendor/example.com/v/v.go": "package v\n",
+		"example.com/a/cmd/main.go":               "package main\n\nfunc main() {}\n",
+		"example.com/a/testdata/t.go":             "package t\n",
+		"example.com/a/broken/b.go":               "package b\n\nfunc {\n",
+	})
+	gitInit(t, filepath.Join(src, "example.com", "a"))
+
+	// The scanners should agree, aside from files the go command leaves out
+	for _, pattern := range []string{"example.com/...", "example.com/a/..."} {
+		want, err := GoList(pattern)
+		if err != nil {
+			t.Fatalf("golist: %s", err)
+		}
+		got, err := BuildList(pattern)
+		if err != nil {
+			t.Fatalf("buildlist: %s", err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %d packages, want %d", pattern, len(got), len(want))
+			continue
+		}
+		sort.Sort(byImportPath(want))
+		for i := range want {
+			g, w := got[i], want[i]
+			// Only the fields filled by both are compared
+			w.Target, g.Target = "", ""
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("%s: package %d:\n got %+v\nwant %+v", pattern, i, g, w)
+			}
+		}
+	}
+
+	// Build tags from the context should be honored
+	defer func(old []string) { BuildContext.BuildTags = old }(BuildContext.BuildTags)
+	BuildContext.BuildTags = []string{"rxtag"}
+	pkgs, err := BuildList("example.com/a")
+	if err != nil || len(pkgs) != 1 {
+		t.Fatalf("buildlist with tags = %v, %v; want one package", pkgs, err)
+	}
+	if got, want := pkgs[0].Imports, []string{"example.com/a/cmd", "example.com/a/vendor/example.com/v", "fmt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imports with rxtag = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Version is a semantic version (see semver.org) parsed from a tag name.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 []string // Prerelease identifiers (e.g. {"rc", "1"})
+	Build               string   // Build metadata, which is ignored when comparing
+
+	parts int // The number of Major, Minor, and Patch which were specified
+}
+
+// ParseVersion parses a tag name as a semantic version.  The name may have a
+// "v" or "go" prefix, and the minor and patch numbers may be omitted, so tags
+// like "v1.2.3-beta.1", "1.2" and "go1" are all understood.  A prerelease may
+// also directly follow the numbers, as in "go1.2rc1".
+func ParseVersion(name string) (*Version, error) {
+	s := name
+	switch {
+	case strings.HasPrefix(s, "v"):
+		s = s[1:]
+	case strings.HasPrefix(s, "go"):
+		s = s[2:]
+	}
+	v := new(Version)
+
+	if i := strings.Index(s, "+"); i >= 0 {
+		s, v.Build = s[:i], s[i+1:]
+		if v.Build == "" {
+			return nil, fmt.Errorf("version %q: empty build metadata", name)
+		}
+	}
+
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for v.parts < len(nums) {
+		if v.parts > 0 {
+			if !strings.HasPrefix(s, ".") {
+				break
+			}
+			s = s[1:]
+		}
+		end := 0
+		for end < len(s) && '0' <= s[end] && s[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("version %q: expected number", name)
+		}
+		n, err := strconv.Atoi(s[:end])
+		if err != nil {
+			return nil, fmt.Errorf("version %q: %s", name, err)
+		}
+		*nums[v.parts] = n
+		v.parts++
+		s = s[end:]
+	}
+
+	switch {
+	case s == "":
+	case s[0] == '-':
+		if s = s[1:]; s == "" {
+			return nil, fmt.Errorf("version %q: empty prerelease", name)
+		}
+		v.Pre = strings.Split(s, ".")
+		for _, id := range v.Pre {
+			if id == "" {
+				return nil, fmt.Errorf("version %q: empty prerelease identifier", name)
+			}
+		}
+	case isLetter(s[0]):
+		// Go-style prereleases like "beta1" are split into "beta" and "1"
+		// so that "rc10" sorts after "rc9".
+		end := 0
+		for end < len(s) && isLetter(s[end]) {
+			end++
+		}
+		v.Pre = []string{s[:end]}
+		if rest := s[end:]; rest != "" {
+			if _, err := strconv.Atoi(rest); err != nil {
+				return nil, fmt.Errorf("version %q: malformed prerelease %q", name, s)
+			}
+			v.Pre = append(v.Pre, rest)
+		}
+	default:
+		return nil, fmt.Errorf("version %q: unexpected %q", name, s)
+	}
+	return v, nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// String returns the canonical form of the version, without any prefix.
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Stable returns true if the version is not a prerelease.
+func (v *Version) Stable() bool {
+	return len(v.Pre) == 0
+}
+
+// Compare returns -1, 0, or 1 if v is less than, equal to, or greater than o
+// respectively, according to semantic version precedence.
+func (v *Version) Compare(o *Version) int {
+	if c := compareInts(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// A prerelease comes before the release itself
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePre(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.Pre), len(o.Pre))
+}
+
+// comparePre compares prerelease identifiers.  Numeric identifiers are
+// compared numerically and come before alphanumeric ones.
+func comparePre(a, b string) int {
+	an, aerr := strconv.Atoi(a)
+	bn, berr := strconv.Atoi(b)
+	switch {
+	case aerr == nil && berr == nil:
+		return compareInts(an, bn)
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// next returns the lowest version above every version matching v in its
+// first n parts (e.g. the next of 1.2.3 for 2 parts is 1.3.0).
+func (v *Version) next(n int) *Version {
+	switch n {
+	case 1:
+		return &Version{Major: v.Major + 1, parts: 3}
+	case 2:
+		return &Version{Major: v.Major, Minor: v.Minor + 1, parts: 3}
+	}
+	return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, parts: 3}
+}
+
+// A Constraint is a set of version comparisons, all of which a version must
+// satisfy to match.
+type Constraint struct {
+	terms []term
+}
+
+type term struct {
+	op string
+	v  *Version
+}
+
+// constraintOps lists the operators understood in constraints, longest first
+// so that prefixes are not matched by mistake.
+var constraintOps = []string{">=", "<=", "!=", "=", ">", "<", "~", "^"}
+
+// ParseConstraint parses a list of comparisons, separated by spaces or
+// commas, like ">=1.2 <2".  The supported operators are =, !=, >, >=, <, <=,
+// ~ (the same minor version, or the same major version if no minor version is
+// given) and ^ (the same major version, or the same minor version for 0.x).  A
+// version without an operator must match exactly.
+func ParseConstraint(s string) (*Constraint, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("constraint %q: empty", s)
+	}
+
+	c := new(Constraint)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		op := "="
+		for _, o := range constraintOps {
+			if strings.HasPrefix(f, o) {
+				op, f = o, f[len(o):]
+				break
+			}
+		}
+		// Allow a space between the operator and the version
+		if f == "" && i+1 < len(fields) {
+			i++
+			f = fields[i]
+		}
+		v, err := ParseVersion(f)
+		if err != nil {
+			return nil, fmt.Errorf("constraint %q: %s", s, err)
+		}
+
+		switch op {
+		case "~":
+			n := v.parts
+			if n > 2 {
+				n = 2
+			}
+			c.terms = append(c.terms, term{">=", v}, term{"<", v.next(n)})
+		case "^":
+			n := 1
+			switch {
+			case v.Major == 0 && v.Minor == 0 && v.parts == 3:
+				n = 3
+			case v.Major == 0 && v.parts >= 2:
+				n = 2
+			}
+			c.terms = append(c.terms, term{">=", v}, term{"<", v.next(n)})
+		default:
+			c.terms = append(c.terms, term{op, v})
+		}
+	}
+	return c, nil
+}
+
+// Match returns true if v satisfies every comparison in the constraint.
+func (c *Constraint) Match(v *Version) bool {
+	for _, t := range c.terms {
+		cmp := v.Compare(t.v)
+		var ok bool
+		switch t.op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
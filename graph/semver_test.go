@@ -0,0 +1,224 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Version string // canonical form, or "" for an error
+	}{
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		{"v1.2", "1.2.0"},
+		{"v1", "1.0.0"},
+		{"go1", "1.0.0"},
+		{"go1.2.1", "1.2.1"},
+		{"go1.2rc1", "1.2.0-rc.1"},
+		{"go1.5beta", "1.5.0-beta"},
+		{"v1.0.0-alpha.1", "1.0.0-alpha.1"},
+		{"v1.0.0-rc.1+build.5", "1.0.0-rc.1+build.5"},
+		{"v1.0.0+20130313", "1.0.0+20130313"},
+		{"master", ""},
+		{"v", ""},
+		{"v1.", ""},
+		{"v1.2.3.4", ""},
+		{"v1.2-", ""},
+		{"v1.2-a..b", ""},
+		{"go1.2rc1x", ""},
+		{"release-1.2", ""},
+	}
+
+	for _, test := range tests {
+		v, err := ParseVersion(test.Name)
+		if test.Version == "" {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %s, want error", test.Name, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %s", test.Name, err)
+			continue
+		}
+		if got, want := v.String(), test.Version; got != want {
+			t.Errorf("ParseVersion(%q) = %s, want %s", test.Name, got, want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// In increasing order of precedence
+	order := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"go1.2rc2",
+		"go1.2rc10",
+		"go1.2",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := range order {
+		for j := range order {
+			a, err := ParseVersion(order[i])
+			if err != nil {
+				t.Fatalf("ParseVersion(%q): %s", order[i], err)
+			}
+			b, err := ParseVersion(order[j])
+			if err != nil {
+				t.Fatalf("ParseVersion(%q): %s", order[j], err)
+			}
+			if got, want := a.Compare(b), compareInts(i, j); got != want {
+				t.Errorf("compare(%q, %q) = %d, want %d", order[i], order[j], got, want)
+			}
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		Constraint string
+		Match      []string
+		NoMatch    []string
+	}{
+		{
+			Constraint: ">=1.2 <2",
+			Match:      []string{"1.2.0", "1.9.9", "v1.2.1"},
+			NoMatch:    []string{"1.1.9", "2.0.0", "3.0.0"},
+		},
+		{
+			Constraint: ">= 1.2, < 2",
+			Match:      []string{"1.2.0", "1.9.9"},
+			NoMatch:    []string{"1.1.0", "2.0.0"},
+		},
+		{
+			Constraint: "~1.2",
+			Match:      []string{"1.2.0", "1.2.9"},
+			NoMatch:    []string{"1.1.0", "1.3.0"},
+		},
+		{
+			Constraint: "~1",
+			Match:      []string{"1.0.0", "1.9.0"},
+			NoMatch:    []string{"0.9.0", "2.0.0"},
+		},
+		{
+			Constraint: "^1.2.3",
+			Match:      []string{"1.2.3", "1.9.0"},
+			NoMatch:    []string{"1.2.2", "2.0.0"},
+		},
+		{
+			Constraint: "^0.2.3",
+			Match:      []string{"0.2.3", "0.2.9"},
+			NoMatch:    []string{"0.3.0", "1.0.0"},
+		},
+		{
+			Constraint: "^0.0.3",
+			Match:      []string{"0.0.3"},
+			NoMatch:    []string{"0.0.4"},
+		},
+		{
+			Constraint: "1.2.3",
+			Match:      []string{"1.2.3", "1.2.3+build"},
+			NoMatch:    []string{"1.2.4"},
+		},
+		{
+			Constraint: ">1 !=1.5.0",
+			Match:      []string{"1.0.1", "1.5.1"},
+			NoMatch:    []string{"1.0.0", "1.5.0"},
+		},
+	}
+
+	for _, test := range tests {
+		c, err := ParseConstraint(test.Constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %s", test.Constraint, err)
+			continue
+		}
+		for _, name := range test.Match {
+			if v, _ := ParseVersion(name); !c.Match(v) {
+				t.Errorf("%q should match %q", test.Constraint, name)
+			}
+		}
+		for _, name := range test.NoMatch {
+			if v, _ := ParseVersion(name); c.Match(v) {
+				t.Errorf("%q should not match %q", test.Constraint, name)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", ">=", ">=x", "1.2 <"} {
+		if _, err := ParseConstraint(bad); err == nil {
+			t.Errorf("ParseConstraint(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestTagListVersions(t *testing.T) {
+	tags := TagList{
+		{"master", "a"},
+		{"v1.2.0", "b"},
+		{"v2.0.0-rc.1", "c"},
+		{"v1.10.0", "d"},
+		{"origin/master", "e"},
+		{"v1.9.1", "f"},
+	}
+	names := func(l TagList) []string {
+		var s []string
+		for _, t := range l {
+			s = append(s, t.Name)
+		}
+		return s
+	}
+
+	sorted := append(TagList(nil), tags...)
+	sorted.SortVersions()
+	want := []string{"v2.0.0-rc.1", "v1.10.0", "v1.9.1", "v1.2.0", "master", "origin/master"}
+	if got := names(sorted); !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %q, want %q", got, want)
+	}
+
+	c, err := ParseConstraint(">=1.5")
+	if err != nil {
+		t.Fatalf("constraint: %s", err)
+	}
+	if got, want := names(tags.Match(c, false)), []string{"v1.10.0", "v1.9.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("match = %q, want %q", got, want)
+	}
+	if got, want := names(tags.Match(c, true)), []string{"v2.0.0-rc.1", "v1.10.0", "v1.9.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("match with prereleases = %q, want %q", got, want)
+	}
+
+	if latest, ok := tags.Latest(false); !ok || latest.Name != "v1.10.0" {
+		t.Errorf("latest = %v, %v; want v1.10.0", latest, ok)
+	}
+	if latest, ok := tags.Latest(true); !ok || latest.Name != "v2.0.0-rc.1" {
+		t.Errorf("latest with prereleases = %v, %v; want v2.0.0-rc.1", latest, ok)
+	}
+	if _, ok := (TagList{{"master", "a"}}).Latest(true); ok {
+		t.Errorf("latest of no versions succeeded")
+	}
+}
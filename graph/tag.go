@@ -14,9 +14,107 @@
 
 package graph
 
+import (
+	"sort"
+
+	"kylelemons.net/go/rx/vcs"
+)
+
 type Tag struct {
 	Name string
 	Rev  string
 }
 
+// Version returns the tag's name parsed as a semantic version, or nil if it
+// is not one.
+func (t Tag) Version() *Version {
+	v, err := ParseVersion(t.Name)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
 type TagList []Tag
+
+// SortVersions sorts the list by semantic version, newest first.  Tags which
+// are not versions are moved to the end, in their original order.
+func (l TagList) SortVersions() {
+	vt := make(byVersion, len(l))
+	for i, t := range l {
+		vt[i] = versionTag{t, t.Version()}
+	}
+	sort.Stable(vt)
+	for i, t := range vt {
+		l[i] = t.Tag
+	}
+}
+
+// Match returns the tags which are versions satisfying c, in their original
+// order.  Prereleases are only included if pre is true.  A nil constraint
+// matches every version.
+func (l TagList) Match(c *Constraint, pre bool) TagList {
+	var match TagList
+	for _, t := range l {
+		v := t.Version()
+		if v == nil || (!pre && !v.Stable()) {
+			continue
+		}
+		if c != nil && !c.Match(v) {
+			continue
+		}
+		match = append(match, t)
+	}
+	return match
+}
+
+// Latest returns the tag with the highest stable version in the list, or the
+// highest version including prereleases if pre is true.  The second return
+// value is false if there are no such tags.
+func (l TagList) Latest(pre bool) (Tag, bool) {
+	var latest Tag
+	var best *Version
+	for _, t := range l {
+		v := t.Version()
+		if v == nil || (!pre && !v.Stable()) {
+			continue
+		}
+		if best == nil || v.Compare(best) > 0 {
+			latest, best = t, v
+		}
+	}
+	return latest, best != nil
+}
+
+type versionTag struct {
+	Tag
+	v *Version
+}
+
+// byVersion sorts tags by descending version, with non-versions last.
+type byVersion []versionTag
+
+func (b byVersion) Len() int      { return len(b) }
+func (b byVersion) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byVersion) Less(i, j int) bool {
+	vi, vj := b[i].v, b[j].v
+	switch {
+	case vi == nil:
+		return false
+	case vj == nil:
+		return true
+	}
+	return vi.Compare(vj) > 0
+}
+
+// tagList converts tags from a vcs.Driver into a TagList.
+func tagList(tags []vcs.Tag) TagList {
+	list := make(TagList, 0, len(tags))
+	for _, t := range tags {
+		list = append(list, Tag{
+			Name: t.Name,
+			Rev:  t.Rev,
+		})
+	}
+	return list
+}
@@ -0,0 +1,179 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"sort"
+)
+
+// An ImportChain is a list of import paths, each of which imports the next.
+type ImportChain []string
+
+// ShortestChain returns the shortest import chain which starts at one of the
+// from packages and ends at one of the to packages, following only the given
+// kinds of imports.  Ties are broken by import path.  If there is no such
+// chain, nil is returned.
+func (g *Graph) ShortestChain(from, to []string, kinds EdgeKind) ImportChain {
+	maps := g.edges(kinds, false)
+	targets := stringSet(to)
+
+	sources := append([]string(nil), from...)
+	sort.Strings(sources)
+	parent := map[string]string{}
+	for _, path := range sources {
+		parent[path] = ""
+	}
+
+	// Breadth-first search, visiting each level in order of import path
+	for frontier := sources; len(frontier) > 0; {
+		var next []string
+		for _, path := range frontier {
+			if targets[path] {
+				var chain ImportChain
+				for ; path != ""; path = parent[path] {
+					chain = append(ImportChain{path}, chain...)
+				}
+				return chain
+			}
+			for _, dep := range sortedEdges(path, maps) {
+				if _, ok := parent[dep]; !ok {
+					parent[dep] = path
+					next = append(next, dep)
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// AllChains returns every import chain which starts at one of the from
+// packages, ends at the first of the to packages it reaches, and does not
+// otherwise pass through a from package or visit any package twice.  The
+// chains are ordered by length and then by import path.  If limit > 0, no
+// more than limit chains are returned, and the search stops once they have
+// been found.
+func (g *Graph) AllChains(from, to []string, kinds EdgeKind, limit int) []ImportChain {
+	maps := g.edges(kinds, false)
+	targets := stringSet(to)
+	sources := stringSet(from)
+	dist := g.chainDistances(targets, sources, kinds)
+
+	starts := append([]string(nil), from...)
+	sort.Strings(starts)
+
+	// Find the chains of each length in turn.  Since a package is only
+	// added to a chain if a target is close enough to end the chain at the
+	// current length, little time is spent on chains which go nowhere, and
+	// the chains of each length are found in order of import path.
+	var chains []ImportChain
+	var chain ImportChain
+	onChain := map[string]bool{}
+	var walk func(path string, length int) bool
+	walk = func(path string, length int) bool {
+		chain = append(chain, path)
+		onChain[path] = true
+		defer func() {
+			onChain[path] = false
+			chain = chain[:len(chain)-1]
+		}()
+
+		if targets[path] {
+			if len(chain) == length {
+				chains = append(chains, append(ImportChain(nil), chain...))
+			}
+			return limit <= 0 || len(chains) < limit
+		}
+		for _, dep := range sortedEdges(path, maps) {
+			d, ok := dist[dep]
+			if !ok || sources[dep] || onChain[dep] || len(chain)+1+d > length {
+				continue
+			}
+			if !walk(dep, length) {
+				return false
+			}
+		}
+		return true
+	}
+	// No chain can be longer than the number of packages which reach a target
+	for length := 1; length <= len(dist); length++ {
+		for _, path := range starts {
+			if d, ok := dist[path]; !ok || 1+d > length {
+				continue
+			}
+			if !walk(path, length) {
+				return chains
+			}
+		}
+	}
+	return chains
+}
+
+// chainDistances returns, for each package from which a chain can reach one of
+// the targets without passing through another target or a source, the fewest
+// imports needed to do so.
+func (g *Graph) chainDistances(targets, sources map[string]bool, kinds EdgeKind) map[string]int {
+	users := g.edges(kinds, true)
+	dist := map[string]int{}
+	var frontier []string
+	for path := range targets {
+		dist[path] = 0
+		frontier = append(frontier, path)
+	}
+	for d := 1; len(frontier) > 0; d++ {
+		var next []string
+		for _, path := range frontier {
+			// Sources only start chains, so nothing leads through them
+			if sources[path] {
+				continue
+			}
+			for _, edges := range users {
+				for user := range edges[path] {
+					if _, ok := dist[user]; !ok && !targets[user] {
+						dist[user] = d
+						next = append(next, user)
+					}
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
+
+// sortedEdges returns the import paths which path has edges to in any of the
+// given maps, in order.
+func sortedEdges(path string, maps []map[string]map[string]bool) []string {
+	seen := map[string]bool{}
+	var deps []string
+	for _, edges := range maps {
+		for dep := range edges[path] {
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
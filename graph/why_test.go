@@ -0,0 +1,105 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestImportChains(t *testing.T) {
+	g := New()
+	g.addRepository("r", "git")
+	g.addRepository("s", "git")
+	// r/a -> r/b -> s/x and r/a -> r/c -> r/b, with r/c also importing s/y
+	// only from its external tests.
+	g.addPackage(&Package{ImportPath: "r/a", RepoRoot: "r", Imports: []string{"r/b", "r/c"}})
+	g.addPackage(&Package{ImportPath: "r/b", RepoRoot: "r", Imports: []string{"s/x"}})
+	g.addPackage(&Package{ImportPath: "r/c", RepoRoot: "r", Imports: []string{"r/b"}, XTestImports: []string{"s/y"}})
+	g.addPackage(&Package{ImportPath: "s/x", RepoRoot: "s"})
+	g.addPackage(&Package{ImportPath: "s/y", RepoRoot: "s", Imports: []string{"s/x"}})
+
+	shortest := []struct {
+		Desc     string
+		From, To []string
+		Kinds    EdgeKind
+		Want     ImportChain
+	}{
+		{"a to x", []string{"r/a"}, []string{"s/x"}, ImportEdges, ImportChain{"r/a", "r/b", "s/x"}},
+		{"c to y", []string{"r/c"}, []string{"s/y"}, ImportEdges, nil},
+		{"c to y (all)", []string{"r/c"}, []string{"s/y"}, AllEdges, ImportChain{"r/c", "s/y"}},
+		{"repo r to repo s", []string{"r/a", "r/b", "r/c"}, []string{"s/x", "s/y"}, ImportEdges, ImportChain{"r/b", "s/x"}},
+		{"x to a", []string{"s/x"}, []string{"r/a"}, AllEdges, nil},
+	}
+	for _, test := range shortest {
+		if got := g.ShortestChain(test.From, test.To, test.Kinds); !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("shortest %s = %q, want %q", test.Desc, got, test.Want)
+		}
+	}
+
+	all := g.AllChains([]string{"r/a"}, []string{"s/x"}, AllEdges, 0)
+	want := []ImportChain{
+		{"r/a", "r/b", "s/x"},
+		{"r/a", "r/c", "r/b", "s/x"},
+		{"r/a", "r/c", "s/y", "s/x"},
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("all chains = %q, want %q", all, want)
+	}
+	if got := g.AllChains([]string{"r/a"}, []string{"s/x"}, AllEdges, 2); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("limited chains = %q, want %q", got, want[:2])
+	}
+
+	// Chains stop at the first target and never pass through another source
+	all = g.AllChains([]string{"r/a", "r/c"}, []string{"r/b", "s/x"}, ImportEdges, 0)
+	want = []ImportChain{{"r/a", "r/b"}, {"r/c", "r/b"}}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("repo chains = %q, want %q", all, want)
+	}
+}
+
+func TestAllChainsLimit(t *testing.T) {
+	// A ladder in which each rung's two packages both import both packages
+	// of the next rung has 2^rungs chains from top to bottom, so finding a
+	// few of them must not enumerate the rest.
+	const rungs = 40
+	g := New()
+	g.addRepository("r", "git")
+	for i := 0; i < rungs; i++ {
+		next := []string{fmt.Sprintf("r/%d/a", i+1), fmt.Sprintf("r/%d/b", i+1)}
+		for _, side := range []string{"a", "b"} {
+			g.addPackage(&Package{ImportPath: fmt.Sprintf("r/%d/%s", i, side), RepoRoot: "r", Imports: next})
+		}
+	}
+	g.addPackage(&Package{ImportPath: fmt.Sprintf("r/%d/a", rungs), RepoRoot: "r"})
+
+	chains := g.AllChains([]string{"r/0/a"}, []string{fmt.Sprintf("r/%d/a", rungs)}, ImportEdges, 3)
+	if len(chains) != 3 {
+		t.Fatalf("found %d chains, want 3", len(chains))
+	}
+	for i, chain := range chains {
+		if len(chain) != rungs+1 {
+			t.Errorf("chain %d has %d packages, want %d", i, len(chain), rungs+1)
+		}
+	}
+	// The chains differ only near the end, in order of import path
+	if last := chains[0][rungs-1]; last != fmt.Sprintf("r/%d/a", rungs-1) {
+		t.Errorf("first chain passes through %q at rung %d", last, rungs-1)
+	}
+	if last := chains[1][rungs-1]; last != fmt.Sprintf("r/%d/b", rungs-1) {
+		t.Errorf("second chain passes through %q at rung %d", last, rungs-1)
+	}
+}
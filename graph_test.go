@@ -0,0 +1,73 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestGraphWriters(t *testing.T) {
+	e := &graph.Export{
+		Nodes: []graph.ExportNode{{ID: "a", Repo: "a"}, {ID: "b", Repo: "b"}, {ID: "fmt", Standard: true}},
+		Edges: []graph.ExportEdge{{From: "a", To: "b"}, {From: "b", To: "a", XTest: true}, {From: "b", To: "fmt"}},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeDOT(buf, e); err != nil {
+		t.Fatalf("dot: %s", err)
+	}
+	if got, want := buf.String(), `digraph deps {
+	"a";
+	"b";
+	"fmt";
+	"a" -> "b";
+	"b" -> "a" [style=dashed];
+	"b" -> "fmt";
+}
+`; got != want {
+		t.Errorf("dot:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := writeJSON(buf, &graph.Export{}); err != nil {
+		t.Fatalf("json: %s", err)
+	}
+	if got, want := buf.String(), "{\n  \"Nodes\": [],\n  \"Edges\": []\n}\n"; got != want {
+		t.Errorf("empty json = %q, want %q", got, want)
+	}
+
+	// The GraphML must round-trip through the same types
+	buf.Reset()
+	if err := writeGraphML(buf, e); err != nil {
+		t.Fatalf("graphml: %s", err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("parse graphml: %s\n%s", err, buf)
+	}
+	if got, want := len(doc.Graph.Nodes), 3; got != want {
+		t.Errorf("graphml has %d nodes, want %d", got, want)
+	}
+	if got, want := len(doc.Graph.Edges), 3; got != want {
+		t.Fatalf("graphml has %d edges, want %d", got, want)
+	}
+	if got := doc.Graph.Edges[1].Data; len(got) != 1 || got[0] != (graphMLData{"xtest", "true"}) {
+		t.Errorf("xtest edge data = %+v", got)
+	}
+}
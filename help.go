@@ -103,6 +103,7 @@ var templateFuncs = template.FuncMap{
 	"trim": func(s string) string {
 		return strings.TrimSpace(s)
 	},
+	"join": strings.Join,
 }
 
 var stdout io.Writer = tabConverter{os.Stdout}
@@ -179,6 +180,39 @@ The general usage is:
 
 ` + generalHelp + `
 
+Version Control Systems
+
+Repositories managed by git, hg, bzr and svn are recognized automatically.
+Other version control systems can be described (and the built-in ones
+replaced) in a vcs.json file in the --rxdir.  The file contains a JSON object
+mapping the name of each system to the commands used to operate it, where
+{{"{{.}}"}} in an argument is replaced by a revision:
+
+	{
+		"mygit": {
+			"Command":      "mygit",
+			"HeadRev":      "HEAD",
+			"RootDir":      ["rev-parse", "--show-toplevel"],
+			"ToRev":        ["checkout", "{{"{{.}}"}}"],
+			"Current":      ["rev-parse", "HEAD"],
+			"Fetch":        ["fetch", "--tags"],
+			"Status":       ["status", "--porcelain", "--untracked-files=no"],
+			"Stash":        ["stash", "push", "-q"],
+			"Unstash":      ["stash", "pop", "-q"],
+			"TagList":      ["log", "--pretty=format:%H%d", "{{"{{.}}"}}"],
+			"TagListRegex": "^([a-z0-9]+) \\((.*)\\)",
+			"Updates":      ["log", "--pretty=format:%H%d", "--all", "^{{"{{.}}"}}"],
+			"UpdatesRegex": "^([a-z0-9]+) \\((.*)\\)"
+		}
+	}
+
+RootDir, ToRev and Current are required.  The TagList and Updates commands
+should list one commit per line, newest first, and the corresponding regular
+expression must capture the commit in $1 and its tags in $2.  Any output from
+Status means the working copy has uncommitted changes, which Stash and Unstash
+set aside and restore for --stash.  The file is checked every time rx starts,
+and rx refuses to run if it is invalid.
+
 See below for a description of the various sub-commands understood by rx.
 {{range .}}
 {{.Name | title}}
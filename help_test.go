@@ -59,7 +59,7 @@ func TestHelp(t *testing.T) {
 		for i, r := range test.Regex {
 			matched, err := regexp.MatchString(r, out)
 			if err != nil {
-				t.Errorf("%s: %q: %s", r, err)
+				t.Errorf("%s: %q: %s", test.Desc, r, err)
 			}
 			if !matched {
 				t.Errorf("%s: regexp[%d] failed: %q\nOutput:\n%s", test.Desc, i, r, out)
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -30,6 +31,16 @@ their repositories.  By default, each repository is listed along with its
 dependencies and contained packages. If a <filter> regular expression is
 provided, only repositories whose root path matches the filter will be listed.
 
+The long format also shows the GOPATH entry containing each repository, and
+lists any copies of its packages in later GOPATH entries, which are hidden by
+the ones listed (rx also warns about these whenever it scans).  The global
+--gopath option restricts this and every other command to the packages in one
+GOPATH entry.
+
+Repositories containing a go.mod file are listed with their module path and
+requirements in the long format, which also shows any requirements by other
+repositories' go.mod files that the checked-out revision does not satisfy.
+
 The -f option takes a template as a format.  The data passed into the
 template invocation is an (rx/graph) Graph, and the default format is:
 
@@ -37,7 +48,25 @@ template invocation is an (rx/graph) Graph, and the default format is:
 
 If you specify --long, the format will be:
 
-` + ind2sp(listTemplateLong),
+` + ind2sp(listTemplateLong) + `
+
+In addition to the Graph methods, the following functions are available to
+templates.  Each accepts an optional edge kind ("imports", the default, to
+follow package and internal test imports; "xtest" to follow only external
+test imports; or "all") and the closure functions accept an optional depth
+(the default, 0, means no limit):
+
+  deps <repo>          Repositories that <repo> transitively depends on
+  users <repo>         Repositories that transitively depend on <repo>
+  pkgdeps <path>       Import paths that the package transitively imports
+  pkgusers <path>      Import paths that transitively import the package
+  toposort <repos>     Repositories in dependency order, dependencies first
+  cycles <repos>       Dependency cycles among the repositories, if any
+
+For example, to list each repository with everything it needs to build:
+
+  rx list -f '{{range toposort .Repository}}{{.}}:{{range deps .}} {{.}}{{end}}
+  {{end}}'`,
 }
 
 var (
@@ -84,16 +113,112 @@ func listFunc(cmd *Command, args ...string) {
 
 func init() {
 	listCmd.Run = listFunc
+
+	templateFuncs["deps"] = func(repo *graph.Repository, opts ...interface{}) ([]*graph.Repository, error) {
+		kinds, depth, err := closureOpts(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Deps.TransitiveRepoDeps(repo, kinds, depth)
+	}
+	templateFuncs["users"] = func(repo *graph.Repository, opts ...interface{}) ([]*graph.Repository, error) {
+		kinds, depth, err := closureOpts(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Deps.TransitiveRepoUsers(repo, kinds, depth)
+	}
+	templateFuncs["pkgdeps"] = func(importPath string, opts ...interface{}) ([]string, error) {
+		kinds, depth, err := closureOpts(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Deps.PackageDeps(importPath, kinds, depth), nil
+	}
+	templateFuncs["pkgusers"] = func(importPath string, opts ...interface{}) ([]string, error) {
+		kinds, depth, err := closureOpts(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Deps.PackageUsers(importPath, kinds, depth), nil
+	}
+	templateFuncs["toposort"] = func(repos interface{}, opts ...interface{}) ([]*graph.Repository, error) {
+		list, kinds, err := topoOpts(repos, opts)
+		if err != nil {
+			return nil, err
+		}
+		sorted, err := Deps.TopoSort(list, kinds)
+		if _, ok := err.(*graph.CycleError); ok {
+			err = nil
+		}
+		return sorted, err
+	}
+	templateFuncs["cycles"] = func(repos interface{}, opts ...interface{}) ([][]*graph.Repository, error) {
+		list, kinds, err := topoOpts(repos, opts)
+		if err != nil {
+			return nil, err
+		}
+		_, err = Deps.TopoSort(list, kinds)
+		if cycles, ok := err.(*graph.CycleError); ok {
+			return cycles.Cycles, nil
+		}
+		return nil, err
+	}
+}
+
+// closureOpts interprets the optional arguments to the closure template
+// functions: an edge kind ("imports", "xtest" or "all") and a depth, in
+// either order.  The defaults are "imports" and no depth limit.
+func closureOpts(opts []interface{}) (kinds graph.EdgeKind, depth int, err error) {
+	kinds = graph.ImportEdges
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case string:
+			if kinds, err = graph.ParseEdgeKind(opt); err != nil {
+				return 0, 0, err
+			}
+		case int:
+			depth = opt
+		default:
+			return 0, 0, fmt.Errorf("unexpected argument %v (%T)", opt, opt)
+		}
+	}
+	return kinds, depth, nil
+}
+
+// topoOpts interprets the arguments to the ordering template functions: a
+// list or map of repositories and an optional edge kind.
+func topoOpts(repos interface{}, opts []interface{}) ([]*graph.Repository, graph.EdgeKind, error) {
+	kinds, _, err := closureOpts(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch repos := repos.(type) {
+	case []*graph.Repository:
+		return repos, kinds, nil
+	case map[string]*graph.Repository:
+		list := make([]*graph.Repository, 0, len(repos))
+		for _, repo := range repos {
+			list = append(list, repo)
+		}
+		return list, kinds, nil
+	}
+	return nil, 0, fmt.Errorf("cannot order %T", repos)
 }
 
 var (
 	listTemplate = `{{range .Repository}}{{.}} :{{range .Packages}}{{$pkg := index $.Package .}} {{$pkg.Name}}{{end}}
 {{end}}`
 
-	listTemplateLong = `{{range .Repository}}Repository ({{.VCS}}) {{.}}:
+	listTemplateLong = `{{range .Repository}}Repository ({{.VCS}}) {{.}}{{with $.Entry .}} in {{.}}{{end}}:
 	Packages:{{range .Packages}}
-		{{$pkg := index $.Package .}}{{$pkg.ImportPath}}{{end}}
-{{with $.RepoDeps .}}	Dependencies:{{range .}}
+		{{$pkg := index $.Package .}}{{$pkg.ImportPath}}{{range index $.Shadowed .}}
+			hides {{.}}{{end}}{{end}}
+{{with .Module}}	Module {{.Path}}:{{range .Require}}
+		{{.Path}} {{.Version}}{{if .Indirect}} (indirect){{end}}{{end}}
+{{end}}{{with $.Mismatches .}}	Required at other versions:{{range .}}
+		{{.}}{{end}}
+{{end}}{{with $.RepoDeps .}}	Dependencies:{{range .}}
 		{{.}}{{end}}
 {{end}}{{with $.RepoUsers .}}	Users:{{range .}}
 		{{.}}{{end}}
@@ -0,0 +1,156 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var lockTimeout = flag.Duration("lock-timeout", 30*time.Second, "How long to wait for another rx to release a lock on the --rxdir")
+
+// lockPoll is how often a busy lock is retried.
+const lockPoll = 100 * time.Millisecond
+
+// lockAbandoned is how old a lock file without a readable holder must be
+// before it is considered to have been abandoned by a process which died
+// before writing it.
+const lockAbandoned = time.Minute
+
+// A rxLock is an advisory lock, held by this process, on a file in the rxdir.
+// On systems with flock(2), the lock is released by the kernel if the process
+// dies; elsewhere, a lock left behind by a process which is no longer running
+// on the same host is detected as stale and broken.
+type rxLock struct {
+	path string
+	file *os.File
+}
+
+// A lockHolder describes the process holding a lock.  It is written into the
+// lock file so that other processes can report (or detect the death of) the
+// holder.
+type lockHolder struct {
+	PID   int
+	Host  string
+	Since time.Time
+}
+
+func (h lockHolder) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", h.PID, h.Host, h.Since.Format(time.Stamp))
+}
+
+// stale returns true if the holder was on this host and is no longer running.
+func (h lockHolder) stale() bool {
+	host, err := os.Hostname()
+	if err != nil || host != h.Host || h.PID <= 0 {
+		return false
+	}
+	return !processAlive(h.PID)
+}
+
+// lockRxDir acquires the lock for the named state file in the rxdir, waiting
+// up to --lock-timeout for another rx to release it.
+func lockRxDir(name string) (*rxLock, error) {
+	dir := expandRxDir()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("lock: unable to create rxdir: %s", err)
+	}
+	return acquireLock(filepath.Join(dir, name+".lock"), *lockTimeout)
+}
+
+// acquireLock acquires the lock at path, waiting up to timeout for it.
+func acquireLock(path string, timeout time.Duration) (*rxLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, busy, err := tryLock(path)
+		if err != nil {
+			return nil, fmt.Errorf("lock %q: %s", path, err)
+		}
+		if !busy {
+			l := &rxLock{path, file}
+			if err := l.writeHolder(); err != nil {
+				l.Unlock()
+				return nil, fmt.Errorf("lock %q: %s", path, err)
+			}
+			return l, nil
+		}
+
+		holder, ok := readHolder(path)
+		if ok && holder.stale() || !ok && abandoned(path) {
+			broken, err := breakLock(path)
+			if err != nil {
+				return nil, fmt.Errorf("breaking stale lock %q: %s", path, err)
+			}
+			if broken {
+				log.Printf("Broke stale lock %q", path)
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			by := "another rx"
+			if ok {
+				by = fmt.Sprintf("another rx (%s)", holder)
+			}
+			return nil, fmt.Errorf("%q is locked by %s; waited %s (see --lock-timeout)", path, by, timeout)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// writeHolder records this process as the holder of the lock.
+func (l *rxLock) writeHolder() error {
+	host, _ := os.Hostname()
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(l.file, "%d %s %d\n", os.Getpid(), host, time.Now().Unix())
+	return err
+}
+
+// readHolder reads the holder of the lock at path, if it can be determined.
+func readHolder(path string) (holder lockHolder, ok bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return holder, false
+	}
+	var since int64
+	if _, err := fmt.Sscanf(string(data), "%d %s %d", &holder.PID, &holder.Host, &since); err != nil {
+		return holder, false
+	}
+	holder.Since = time.Unix(since, 0)
+	return holder, true
+}
+
+// abandoned returns true if the lock file at path has been unchanged for long
+// enough that its holder must have died before recording itself.
+func abandoned(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && time.Since(fi.ModTime()) > lockAbandoned
+}
+
+// Unlock releases the lock.  It does nothing if the lock was already released.
+func (l *rxLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	return unlock(l.path, file)
+}
@@ -0,0 +1,56 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBreakStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rx-lock-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.lock")
+
+	// Without flock(2), the lock file itself is the lock, so one left behind
+	// by a dead process must be broken rather than waited on.
+	const dead = 1 << 30
+	if processAlive(dead) {
+		t.Skipf("unable to detect dead processes on this system")
+	}
+	host, _ := os.Hostname()
+	ioutil.WriteFile(path, []byte(fmt.Sprintf("%d %s %d\n", dead, host, time.Now().Unix())), 0644)
+	if _, busy, err := tryLock(path); err != nil || !busy {
+		t.Fatalf("trylock = busy %v, %v; want busy", busy, err)
+	}
+	l, err := acquireLock(path, 0)
+	if err != nil {
+		t.Fatalf("lock with stale holder: %s", err)
+	}
+	defer l.Unlock()
+	if holder, ok := readHolder(path); !ok || holder.PID != os.Getpid() {
+		t.Errorf("holder = %+v, %v; want pid %d", holder, ok, os.Getpid())
+	}
+}
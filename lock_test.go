@@ -0,0 +1,99 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rx-lock-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.lock")
+
+	held, err := acquireLock(path, 0)
+	if err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	holder, ok := readHolder(path)
+	if !ok || holder.PID != os.Getpid() {
+		t.Errorf("holder = %+v, %v; want pid %d", holder, ok, os.Getpid())
+	}
+
+	// A second lock must wait for the first, and then give up
+	start := time.Now()
+	_, err = acquireLock(path, 3*lockPoll)
+	if err == nil {
+		t.Fatalf("second lock succeeded while the first was held")
+	}
+	if waited := time.Since(start); waited < 3*lockPoll {
+		t.Errorf("second lock gave up after %s, want at least %s", waited, 3*lockPoll)
+	}
+	if want := fmt.Sprintf("pid %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not mention %q", err, want)
+	}
+
+	// ... but succeeds once it is released
+	released := make(chan error)
+	go func() {
+		time.Sleep(2 * lockPoll)
+		released <- held.Unlock()
+	}()
+	second, err := acquireLock(path, time.Second)
+	if err != nil {
+		t.Fatalf("lock after release: %s", err)
+	}
+	if err := <-released; err != nil {
+		t.Errorf("unlock: %s", err)
+	}
+	if err := held.Unlock(); err != nil {
+		t.Errorf("second unlock of a released lock: %s", err)
+	}
+	second.Unlock()
+
+	// A holder which has exited is stale
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("unable to run a process: %s", err)
+	}
+	host, _ := os.Hostname()
+	dead := lockHolder{PID: cmd.Process.Pid, Host: host, Since: time.Now()}
+	if !dead.stale() {
+		t.Errorf("holder %s is not stale", dead)
+	}
+	if self := (lockHolder{PID: os.Getpid(), Host: host}); self.stale() {
+		t.Errorf("holder %s (this process) is stale", self)
+	}
+
+	// A lock file naming a dead holder does not stop the lock from being
+	// taken: with flock(2) the kernel released the lock when the holder
+	// exited, and elsewhere the file is recognized as stale and broken.
+	ioutil.WriteFile(path, []byte(fmt.Sprintf("%d %s %d\n", dead.PID, host, dead.Since.Unix())), 0644)
+	l, err := acquireLock(path, 0)
+	if err != nil {
+		t.Fatalf("lock with stale holder: %s", err)
+	}
+	l.Unlock()
+}
@@ -30,8 +30,13 @@ var (
 var commands = []*Command{
 	helpCmd,
 	listCmd,
+	whyCmd,
+	graphCmd,
+	cyclesCmd,
 	tagsCmd,
+	fetchCmd,
 	preCmd,
+	updateCmd,
 	cabCmd,
 	cpointCmd,
 }
@@ -52,8 +57,24 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	Load()
-	defer Save()
+	if err := LoadTools(); err != nil {
+		fmt.Fprintf(stdout, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := SetScanner(); err != nil {
+		fmt.Fprintf(stdout, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := Load(); err != nil {
+		fmt.Fprintf(stdout, "error: load: %s\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := Save(); err != nil {
+			fmt.Fprintf(stdout, "error: save: %s\n", err)
+		}
+	}()
 
 	var found []*Command
 	sub, args := args[0], args[1:]
@@ -72,6 +93,10 @@ find:
 		fmt.Fprintf(stdout, "error: scan: %s", err)
 		os.Exit(1)
 	}
+	if err := ScopeGOPATH(); err != nil {
+		fmt.Fprintf(stdout, "error: %s\n", err)
+		os.Exit(1)
+	}
 
 	switch cnt := len(found); cnt {
 	case 1:
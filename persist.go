@@ -15,18 +15,17 @@
 package main
 
 import (
-	"encoding/gob"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"kylelemons.net/go/rx/graph"
 )
 
-const rxFileVersion = 1
-
 func defEnv(name, def string) string {
 	if os.Getenv(name) == "" {
 		return def
@@ -35,96 +34,154 @@ func defEnv(name, def string) string {
 }
 
 var (
-	rescan = flag.Bool("rescan", false, "Force a rescan of repositories")
-	rxDir  = flag.String("rxdir", defEnv("RX_DIR", filepath.Join("$HOME", ".rx")), "Directory in which to save state")
-	asave  = flag.Bool("autosave", true, "Automatically save dependency graph (disable for concurrent runs)")
-	maxAge = flag.Duration("max-age", 1*time.Hour, "Nominal amount of time before a rescan is done")
+	rescan    = flag.Bool("rescan", false, "Force a rescan of repositories")
+	rxDir     = flag.String("rxdir", defEnv("RX_DIR", filepath.Join("$HOME", ".rx")), "Directory in which to save state")
+	asave     = flag.Bool("autosave", true, "Automatically save dependency graph")
+	maxAge    = flag.Duration("max-age", 1*time.Hour, "Nominal amount of time before a rescan is done")
+	incr      = flag.Bool("incremental", true, "Only rescan packages which have changed since the last scan")
+	lister    = flag.String("scanner", "golist", "How to find packages: golist (run go list) or gobuild (read them with go/build)")
+	buildTags = flag.String("tags", "", "Space-separated build tags to use with --scanner=gobuild")
+	gopath    = flag.String("gopath", "", "Only consider packages in this GOPATH entry (or unique substring of one)")
 )
 
 var Deps = graph.New()
 
+// fullDeps holds the complete graph while Deps is scoped to the GOPATH entry
+// scopeEntry, so that the complete graph is what gets saved.
+var (
+	fullDeps   *graph.Graph
+	scopeEntry string
+)
+
 func expandRxDir() string {
 	return os.ExpandEnv(*rxDir)
 }
 
+// SetScanner configures the package scanner selected on the command line.
+func SetScanner() error {
+	list, ok := graph.Listers[*lister]
+	if !ok {
+		return fmt.Errorf("unknown scanner %q", *lister)
+	}
+	graph.ListPackages = list
+	graph.BuildContext.BuildTags = strings.Fields(*buildTags)
+	return nil
+}
+
+// Scan rescans the dependency graph if it is empty or stale, and warns about
+// any packages hidden by copies in earlier GOPATH entries.
 func Scan() error {
 	var (
 		stale = time.Since(Deps.LastScan) > *maxAge
 		empty = len(Deps.Repository) == 0
 		force = *rescan
 	)
-	if !stale && !empty && !force {
+	var err error
+	switch {
+	case empty || force:
+		err = Deps.Scan("all")
+	case stale && *incr:
+		err = Deps.ScanChanged()
+	case stale:
+		err = Deps.Scan("all")
+	default:
 		return nil
 	}
-	return Deps.Scan("all")
+	if err != nil {
+		return err
+	}
+
+	// The go command never sees these, so they are probably stale copies
+	for _, s := range Deps.Shadows() {
+		log.Printf("Warning: %s in %s is hidden by the copy in %s", s.ImportPath, s.Dir, s.ActiveDir)
+	}
+	return nil
 }
 
-func Load() {
-	if *rescan {
-		return
+// ScopeGOPATH restricts Deps to the GOPATH entry selected on the command line,
+// if any.  It must be called after Scan.
+func ScopeGOPATH() error {
+	if *gopath == "" {
+		return nil
 	}
+	entry, err := graph.FindGOPATH(*gopath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Only considering packages in %q", entry)
+	fullDeps, Deps, scopeEntry = Deps, Deps.Scope(entry), entry
+	return nil
+}
 
-	graphFile := filepath.Join(expandRxDir(), "graph")
+// Rescan scans the packages matching pattern into Deps.  If Deps is scoped to a
+// GOPATH entry, the complete graph is scanned and then scoped again, so that
+// the scan is not lost when the graph is saved.
+func Rescan(pattern string) error {
+	if fullDeps == nil {
+		return Deps.Scan(pattern)
+	}
+	if err := fullDeps.Scan(pattern); err != nil {
+		return err
+	}
+	Deps = fullDeps.Scope(scopeEntry)
+	return nil
+}
 
-	// Open the graphFile
-	repo, err := os.Open(graphFile)
-	if err != nil {
-		log.Printf("Skipping load: %s", err)
-		os.Remove(graphFile)
-		return
+// Load reads the saved dependency graph, if there is one.  An unreadable graph
+// is moved aside so that a new one will be scanned.  An error is returned if
+// another rx holds the lock on the graph for longer than --lock-timeout.
+func Load() error {
+	if *rescan {
+		return nil
 	}
-	d := gob.NewDecoder(repo)
 
-	// Check the version
-	var version int
-	if err := d.Decode(&version); err != nil {
-		log.Printf("Load: bad file version: %s", err)
-		os.Remove(graphFile)
-		return
-	} else if got, want := version, rxFileVersion; got != want {
-		log.Printf("Load: file version mismatch: %d, want %d", got, want)
-		os.Remove(graphFile)
-		return
+	l, err := lockRxDir("graph")
+	if err != nil {
+		return err
 	}
+	defer l.Unlock()
 
-	// Load the dependency graph
+	graphFile := filepath.Join(expandRxDir(), "graph")
 	log.Printf("Loading graph from %q...", graphFile)
-	if err := d.Decode(&Deps); err != nil {
-		log.Printf("Load: error loading graph: %s", err)
-		os.Remove(graphFile)
+	g, err := graph.ReadFile(graphFile)
+	if _, newer := err.(*graph.VersionError); newer {
+		// Leave the file for the newer rx which wrote it
+		log.Printf("Load: %s; not saving", err)
+		*asave = false
+		return nil
 	}
+	switch {
+	case os.IsNotExist(err):
+		log.Printf("Skipping load: %s", err)
+	case err != nil:
+		// Keep the unreadable file around in case it is useful
+		log.Printf("Load: %s", err)
+		if err := os.Rename(graphFile, graphFile+".bad"); err != nil {
+			log.Printf("Load: %s", err)
+		}
+	default:
+		Deps = g
+	}
+	return nil
 }
 
-func Save() {
+// Save writes the dependency graph, unless --autosave=false.
+func Save() error {
 	if !*asave {
-		return
-	}
-
-	// Make sure the directory exists
-	if err := os.MkdirAll(expandRxDir(), 0750); err != nil {
-		log.Printf("Save: unable to create rxdir: %s", err)
-		return
+		return nil
 	}
 
-	// Open the graphFile
-	graphFile := filepath.Join(expandRxDir(), "graph")
-	repo, err := os.Create(graphFile)
+	l, err := lockRxDir("graph")
 	if err != nil {
-		log.Printf("Save: error opening .rx/repo file: %s", err)
-		return
+		return err
 	}
-	e := gob.NewEncoder(repo)
+	defer l.Unlock()
 
-	// Write the file version
-	if err := e.Encode(rxFileVersion); err != nil {
-		log.Printf("Save: error writing version: %s", err)
-		return
+	g := Deps
+	if fullDeps != nil {
+		g = fullDeps
 	}
-
-	// Save the dependency graph
+	graphFile := filepath.Join(expandRxDir(), "graph")
 	log.Printf("Saving graph to %q...", graphFile)
-	if err := e.Encode(Deps); err != nil {
-		log.Printf("Save: error encoding graph: %s", err)
-		return
-	}
+	return g.WriteFile(graphFile)
 }
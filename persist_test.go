@@ -0,0 +1,94 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestRescanScoped(t *testing.T) {
+	for _, bin := range []string{"go", "git"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found: %s", bin, err)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "rx-persist-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	first, second := filepath.Join(tmp, "first"), filepath.Join(tmp, "second")
+	path := first + string(filepath.ListSeparator) + second
+	for key, value := range map[string]string{"GOPATH": path, "GO111MODULE": "off", "GOFLAGS": ""} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+	defer func(d, c string) { build.Default.GOPATH, graph.BuildContext.GOPATH = d, c }(build.Default.GOPATH, graph.BuildContext.GOPATH)
+	build.Default.GOPATH, graph.BuildContext.GOPATH = path, path
+
+	// addRepo creates a repository holding one package in the GOPATH entry.
+	addRepo := func(entry, pkg string) {
+		dir := filepath.Join(entry, "src", filepath.FromSlash(pkg))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %s", err)
+		}
+		gitRun(t, dir, "init", "-q")
+		gitCommit(t, dir, "p.go", "package "+filepath.Base(dir)+"\n")
+	}
+	addRepo(first, "example.com/one")
+	addRepo(second, "example.com/two")
+
+	defer func(d, f *graph.Graph, e, g string) {
+		Deps, fullDeps, scopeEntry, *gopath = d, f, e, g
+	}(Deps, fullDeps, scopeEntry, *gopath)
+	Deps, fullDeps = graph.New(), nil
+	if err := Deps.Scan("all"); err != nil {
+		t.Fatalf("scan: %s", err)
+	}
+	*gopath = second
+	if err := ScopeGOPATH(); err != nil {
+		t.Fatalf("scope: %s", err)
+	}
+
+	// A rescan while scoped must reach both the scoped and complete graphs
+	addRepo(second, "example.com/three")
+	if err := Rescan("example.com/three"); err != nil {
+		t.Fatalf("rescan: %s", err)
+	}
+	for _, test := range []struct {
+		Desc  string
+		Graph *graph.Graph
+		Pkg   string
+		Want  bool
+	}{
+		{"scoped", Deps, "example.com/three", true},
+		{"scoped", Deps, "example.com/one", false},
+		{"complete", fullDeps, "example.com/three", true},
+		{"complete", fullDeps, "example.com/one", true},
+	} {
+		if _, got := test.Graph.Package[test.Pkg]; got != test.Want {
+			t.Errorf("%s graph has %s = %v, want %v", test.Desc, test.Pkg, got, test.Want)
+		}
+	}
+}
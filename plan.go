@@ -0,0 +1,167 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"kylelemons.net/go/rx/graph"
+	"kylelemons.net/go/rx/vcs"
+)
+
+// A pinPlan describes what RepoVersion.Apply would do, as found by looking at
+// the local repository without changing anything.
+type pinPlan struct {
+	Version      *RepoVersion
+	Excluded     bool              // The version was filtered out and will not be applied
+	Repo         *graph.Repository // The local repository, or nil if it has not been scanned
+	Head         string            // The current revision of Repo
+	Branch       string            // The current branch of Repo, if any
+	Dirty        bool              // Whether Repo has uncommitted changes
+	DirtyChecked bool              // Whether Dirty could be determined
+	Present      bool              // Whether the target revision is in Repo
+	Checked      bool              // Whether Present could be determined
+	Err          error             // Why Repo could not be inspected, if it couldn't
+}
+
+// planPin inspects the local repository for dep.
+func planPin(dep *RepoVersion) *pinPlan {
+	plan := &pinPlan{Version: dep, Repo: dep.localRepo()}
+	repo := plan.Repo
+	if repo == nil {
+		return plan
+	}
+
+	var err error
+	if plan.Head, err = repo.Head(); err != nil {
+		plan.Err = err
+		return plan
+	}
+	if plan.Branch, err = repo.Branch(); err != nil {
+		plan.Err = err
+		return plan
+	}
+	// As when applying, a repository which can't be checked for uncommitted
+	// changes is assumed not to have any.
+	switch plan.Dirty, err = repo.Dirty(); err {
+	case nil:
+		plan.DirtyChecked = true
+	case vcs.ErrUnsupported:
+	default:
+		plan.Err = err
+		return plan
+	}
+	// Not every version control system can check for a revision, but
+	// that doesn't stop it from being pinned.
+	if plan.Present, err = repo.HasRev(dep.Head); err == nil {
+		plan.Checked = true
+	}
+	return plan
+}
+
+// current returns true if the repository is already pinned as requested.
+func (p *pinPlan) current() bool {
+	return p.Head == p.Version.Head && (p.Version.Branch == "" || p.Branch == p.Version.Branch)
+}
+
+// action returns a one-word summary of what applying the version would do
+// under policy, and whether it is expected to succeed.
+func (p *pinPlan) action(policy *dirtyPolicy) (string, bool) {
+	switch {
+	case p.Excluded:
+		return "skip", true
+	case p.Err != nil:
+		return "error", false
+	case p.Repo == nil:
+		return "fetch", true
+	case p.Dirty && !*policy.force && !*policy.stash:
+		return "blocked", false
+	case p.current():
+		return "none", true
+	case p.Checked && !p.Present:
+		return "missing", false
+	}
+	return "pin", true
+}
+
+// notes explains the action chosen for the plan under policy.
+func (p *pinPlan) notes(policy *dirtyPolicy) string {
+	var notes []string
+	switch {
+	case p.Excluded:
+		notes = append(notes, "excluded by --filter or --exclude")
+	case p.Err != nil:
+		notes = append(notes, p.Err.Error())
+	case p.Repo == nil:
+		notes = append(notes, "not found locally, would be downloaded with go get")
+	}
+	if p.Dirty {
+		switch {
+		case *policy.stash:
+			notes = append(notes, "uncommitted changes would be stashed")
+		case *policy.force:
+			notes = append(notes, "uncommitted changes would be carried along")
+		default:
+			notes = append(notes, "uncommitted changes (use --stash or --force)")
+		}
+	}
+	if p.Repo != nil && p.Err == nil && !p.current() {
+		if !p.DirtyChecked {
+			notes = append(notes, "unable to check for uncommitted changes")
+		}
+		switch {
+		case !p.Checked:
+			notes = append(notes, "unable to check for target revision")
+		case !p.Present:
+			notes = append(notes, "target revision not present locally")
+		}
+	}
+	return strings.Join(notes, "; ")
+}
+
+// revString formats a revision along with its branch, if any.
+func revString(rev, branch string) string {
+	if rev == "" {
+		rev = "-"
+	}
+	if branch != "" {
+		return fmt.Sprintf("%s (%s)", rev, branch)
+	}
+	return rev
+}
+
+// printPlans writes a line for each plan showing the action applying it would
+// take under policy, the current and target revisions, and any notes.  An
+// error is returned if any of the versions are not expected to apply cleanly.
+func printPlans(w io.Writer, plans []*pinPlan, policy *dirtyPolicy) error {
+	tw := tabify(w)
+	var failed int
+	for _, p := range plans {
+		action, ok := p.action(policy)
+		if !ok {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s -> %s\t%s\n", action, p.Version.Pattern,
+			revString(p.Head, p.Branch), revString(p.Version.Head, p.Version.Branch),
+			p.notes(policy))
+	}
+	tw.Flush()
+	if failed > 0 {
+		return fmt.Errorf("dry run: %d of %d repositories could not be pinned", failed, len(plans))
+	}
+	return nil
+}
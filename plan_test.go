@@ -0,0 +1,165 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestPlanPin(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+	root, err := ioutil.TempDir("", "rx-plan-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	gitRun(t, root, "init", "-q")
+	gitCommit(t, root, "lib.go", "package lib // v1\n")
+	v1 := gitRun(t, root, "rev-parse", "HEAD")
+	gitCommit(t, root, "lib.go", "package lib // v2\n")
+	v2 := gitRun(t, root, "rev-parse", "HEAD")
+	gitRun(t, root, "checkout", "-q", v1)
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	Deps.Repository[root] = &graph.Repository{Root: root, VCS: "git", Packages: []string{"example.com/lib"}}
+	Deps.Package["example.com/lib"] = &graph.Package{ImportPath: "example.com/lib", RepoRoot: root}
+
+	lib := func(rev string) *RepoVersion {
+		return &RepoVersion{Pattern: "example.com/lib", Packages: []string{"example.com/lib"}, Head: rev}
+	}
+	absent := &RepoVersion{Pattern: "example.com/absent", Packages: []string{"example.com/absent"}, Head: "abc"}
+
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	policy := newDirtyPolicy(fs)
+
+	type planTest struct {
+		Desc   string
+		Plan   *pinPlan
+		Flags  []string
+		Action string
+		OK     bool
+	}
+	tests := []planTest{
+		{"current", planPin(lib(v1)), nil, "none", true},
+		{"newer", planPin(lib(v2)), nil, "pin", true},
+		{"missing", planPin(lib("0123456789abcdef0123456789abcdef01234567")), nil, "missing", false},
+		{"absent", planPin(absent), nil, "fetch", true},
+		{"excluded", &pinPlan{Version: absent, Excluded: true}, nil, "skip", true},
+	}
+
+	// Making the repository dirty blocks it unless the policy allows it
+	if err := ioutil.WriteFile(filepath.Join(root, "lib.go"), []byte("package lib // local\n"), 0644); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	dirty := planPin(lib(v2))
+	tests = append(tests, []planTest{
+		{"dirty", dirty, nil, "blocked", false},
+		{"dirty stash", dirty, []string{"--stash"}, "pin", true},
+	}...)
+
+	for _, test := range tests {
+		fs.Parse(append([]string{"--stash=false", "--force=false"}, test.Flags...))
+		if action, ok := test.Plan.action(policy); action != test.Action || ok != test.OK {
+			t.Errorf("%s: action = %q, %v; want %q, %v", test.Desc, action, ok, test.Action, test.OK)
+		}
+	}
+	fs.Parse([]string{"--stash=false"})
+
+	// A dry run must not touch the repository
+	head := gitRun(t, root, "rev-parse", "HEAD")
+	cpoints := &CPointFile{Checkpoints: map[int]*CPoint{
+		1: {Versions: []*RepoVersion{lib(v2), absent}},
+	}}
+	buf := new(bytes.Buffer)
+	err = cpoints.Plan(buf, 1, regexp.MustCompile(".*"), regexp.MustCompile("absent"), policy)
+	if err == nil {
+		t.Errorf("plan of a blocked checkpoint succeeded")
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"blocked example.com/lib    " + v1 + " -> " + v2 + " uncommitted changes (use --stash or --force)\n",
+		"skip    example.com/absent - -> abc",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan output does not contain %q:\n%s", want, out)
+		}
+	}
+	if now := gitRun(t, root, "rev-parse", "HEAD"); now != head {
+		t.Errorf("dry run moved HEAD from %s to %s", head, now)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "lib.go")); string(data) != "package lib // local\n" {
+		t.Errorf("dry run changed the working copy: %q", data)
+	}
+}
+
+func TestDryRunRequiresMode(t *testing.T) {
+	defer func(old io.Writer) { stdout = old }(stdout)
+	buf := new(bytes.Buffer)
+	stdout = buf
+
+	tests := []struct {
+		Desc  string
+		Cmd   *Command
+		Args  []string
+		Reset []string
+		Want  string
+	}{
+		{
+			Desc:  "cabinet --build",
+			Cmd:   cabCmd,
+			Args:  []string{"--build", "--dry-run", "repo"},
+			Reset: []string{"--build=false", "--dry-run=false"},
+			Want:  "--dry-run requires --open",
+		},
+		{
+			Desc:  "checkpoint --save",
+			Cmd:   cpointCmd,
+			Args:  []string{"--save=x", "--dry-run"},
+			Reset: []string{"--save=", "--dry-run=false"},
+			Want:  "--dry-run requires --apply",
+		},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		func() {
+			defer test.Cmd.Flag.Parse(test.Reset)
+			defer func() {
+				if _, ok := recover().(fatal); !ok {
+					t.Errorf("%s: did not fail", test.Desc)
+				}
+			}()
+			test.Cmd.Flag.Parse(test.Args)
+			test.Cmd.Run(test.Cmd, test.Cmd.Flag.Args()...)
+		}()
+		if !strings.Contains(buf.String(), test.Want) {
+			t.Errorf("%s: output does not contain %q:\n%s", test.Desc, test.Want, buf)
+		}
+	}
+}
@@ -15,10 +15,12 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
-	//"strings"
+	"strings"
 
 	"kylelemons.net/go/rx/graph"
 )
@@ -37,18 +39,49 @@ After updating, prescribe will test, build, and the install each package
 in the updated repository.  These steps can be disabled via flags such as
 "rx prescribe --test=false repo tag".
 
+Unless --cascade=false is specified, every repository which depends upon the
+updated repository, directly or indirectly (including through external test
+packages), is then processed in the same way in dependency order.  The result
+for each dependent repository is reported, and if any of them fail the update
+is considered to have failed.  If the repository is part of a dependency cycle
+(see "rx cycles"), a warning is printed, since the order in which the other
+repositories in the cycle are processed is then arbitrary.
+
+A repository with uncommitted changes will not be updated unless --force is
+specified, in which case the changes are carried along (if the version control
+system allows it), or --stash is specified, in which case the changes are set
+aside before the update and restored once it has finished.
+
 By default, this will not link and install affected binaries; to turn this
 behavior on, see the --link option.`,
 }
 
-var (
-	preBuild    = preCmd.Flag.Bool("build", true, "build all updated packages")
-	preLink     = preCmd.Flag.Bool("link", false, "link and install all updated binaries")
-	preTest     = preCmd.Flag.Bool("test", true, "test all updated packages")
-	preInstall  = preCmd.Flag.Bool("install", true, "install all updated packages")
-	preCascade  = preCmd.Flag.Bool("cascade", true, "recursively process depending packages too")
-	preFallback = preCmd.Flag.Bool("rollback", true, "automatically roll back failed upgrade")
-)
+var prePipeline = newPipeline(&preCmd.Flag)
+
+// A pipeline holds the settings used to validate a repository after it has
+// been moved to a new revision.
+type pipeline struct {
+	build    *bool
+	link     *bool
+	test     *bool
+	install  *bool
+	cascade  *bool
+	rollback *bool
+	dirty    *dirtyPolicy
+}
+
+// newPipeline registers the pipeline flags in the given flag set.
+func newPipeline(fs *flag.FlagSet) *pipeline {
+	return &pipeline{
+		build:    fs.Bool("build", true, "build all updated packages"),
+		link:     fs.Bool("link", false, "link and install all updated binaries"),
+		test:     fs.Bool("test", true, "test all updated packages"),
+		install:  fs.Bool("install", true, "install all updated packages"),
+		cascade:  fs.Bool("cascade", true, "recursively process depending packages too"),
+		rollback: fs.Bool("rollback", true, "automatically roll back failed upgrade"),
+		dirty:    newDirtyPolicy(fs),
+	}
+}
 
 func preFunc(cmd *Command, args ...string) {
 	if len(args) != 2 {
@@ -62,28 +95,57 @@ func preFunc(cmd *Command, args ...string) {
 		cmd.Fatalf("<repo>: %s", err)
 	}
 
+	if err := prePipeline.prescribe(cmd, repo, repoTag); err != nil {
+		cmd.Fatalf("%s", err)
+	}
+}
+
+// prescribe moves repo to the given revision and then builds, tests, and
+// installs it as configured.  If any step fails and rollback is enabled, the
+// repository is returned to its original revision.  Repositories with
+// uncommitted changes are handled according to the pipeline's dirty policy.
+func (p *pipeline) prescribe(cmd *Command, repo *graph.Repository, repoTag string) (err error) {
+	warnCycle(cmd, repo)
+
 	fallback, err := repo.Head()
 	if err != nil {
-		cmd.Fatalf("failure to determine head: %s", err)
+		return fmt.Errorf("failure to determine head: %s", err)
+	}
+	fallbackBranch, err := repo.Branch()
+	if err != nil {
+		return fmt.Errorf("failure to determine branch: %s", err)
 	}
+	restore, err := p.dirty.prepare(repo)
+	if err != nil {
+		return err
+	}
+	// This runs after any rollback, so stashed changes go back where they were
 	defer func() {
-		if *preFallback && fallback != "" {
+		if uerr := restore(); uerr != nil {
+			cmd.Errorf("restoring uncommitted changes: %s", uerr)
+			if err == nil {
+				err = uerr
+			}
+		}
+	}()
+	defer func() {
+		if err != nil && *p.rollback {
 			cmd.Errorf("errors detected, falling back to %q...", fallback)
-			if err := repo.ToRev(fallback); err != nil {
+			if err := repo.ToBranch(fallbackBranch, fallback, false); err != nil {
 				cmd.Errorf("during fallback: %s", err)
 			}
 		}
 	}()
 
 	if err := repo.ToRev(repoTag); err != nil {
-		cmd.Fatalf("failure to change rev to %q: %s", repoTag, err)
+		return fmt.Errorf("failure to change rev to %q: %s", repoTag, err)
 	}
 
 	do := func(repo *graph.Repository, subCmd string) error {
 		for _, importPath := range repo.Packages {
 			pkg, ok := Deps.Package[importPath]
 			if !ok {
-				cmd.Fatalf("unknown package %q", importPath)
+				return fmt.Errorf("unknown package %q", importPath)
 			}
 			switch subCmd {
 			case "test":
@@ -91,11 +153,11 @@ func preFunc(cmd *Command, args ...string) {
 					continue
 				}
 				// Install dependencies so we don't get complaints
-				if *preInstall {
+				if *p.install {
 					exec.Command("go", "test", "-i", pkg.ImportPath).Run()
 				}
 			case "install":
-				if !*preLink && pkg.IsBinary() {
+				if !*p.link && pkg.IsBinary() {
 					continue
 				}
 			}
@@ -111,61 +173,93 @@ func preFunc(cmd *Command, args ...string) {
 		return nil
 	}
 
-	rebuilt := map[*graph.Repository]bool{}
-	process := func(repo *graph.Repository) {
+	process := func(repo *graph.Repository) error {
 		log.Printf("Processing %s", repo)
-		if *preBuild {
+		if *p.build {
 			log.Printf(" - Build")
 			if err := do(repo, "build"); err != nil {
-				cmd.Fatalf("build failed: %q broke %q", repoTag, repo)
+				return fmt.Errorf("build failed: %q broke %q", repoTag, repo)
 			}
 		}
 
-		if *preTest {
+		if *p.test {
 			log.Printf(" - Test")
 			if err := do(repo, "test"); err != nil {
-				cmd.Fatalf("test failed: %q broke %q: %s", repoTag, repo, err)
+				return fmt.Errorf("test failed: %q broke %q: %s", repoTag, repo, err)
 			}
 		}
 
-		if *preInstall {
+		if *p.install {
 			log.Printf(" - Install")
 			if err := do(repo, "install"); err != nil {
-				cmd.Fatalf("install failed: %q broke %q", repoTag, repo)
+				return fmt.Errorf("install failed: %q broke %q", repoTag, repo)
 			}
 		}
+		return nil
+	}
 
-		if *preCascade {
-			deps, err := Deps.RepoDeps(repo)
-			if err != nil {
-				cmd.Fatalf("cascade: %s", err)
-			}
-			for _, dep := range deps {
-				// Don't process repos we've already rebuilt
-				if _, ok := rebuilt[dep]; ok {
-					continue
-				}
-				log.Printf(" - Cascade: %s", dep)
-				rebuilt[dep] = false
-			}
-		}
+	if err := process(repo); err != nil {
+		return err
+	}
+	if !*p.cascade {
+		return nil
 	}
 
-	cascade := true
-	rebuilt[repo] = false
+	users, err := cascadeUsers(repo)
+	if err != nil {
+		return fmt.Errorf("cascade: %s", err)
+	}
 
-	for cascade {
-		cascade = false
-		for next, processed := range rebuilt {
-			if !processed {
-				cascade = true
-				rebuilt[next] = true
-				process(next)
-			}
+	// Process every dependent repository so that the report is complete,
+	// even if an earlier one fails.
+	var failed int
+	tw := tabify(stdout)
+	for _, user := range users {
+		log.Printf(" - Cascade: %s", user)
+		if err := process(user); err != nil {
+			fmt.Fprintf(tw, "FAIL\t%s\t%s\n", user, err)
+			failed++
+			continue
 		}
+		fmt.Fprintf(tw, "ok\t%s\t\n", user)
+	}
+	tw.Flush()
+	if failed > 0 {
+		return fmt.Errorf("cascade: %q broke %d of %d dependent repositories", repoTag, failed, len(users))
 	}
+	return nil
+}
+
+// warnCycle warns if repo is part of a dependency cycle, since the order in
+// which the repositories in the cycle are built and cascaded to is then
+// arbitrary.
+func warnCycle(cmd *Command, repo *graph.Repository) {
+	cycle, err := Deps.RepoCycle(repo, graph.ImportEdges)
+	if err != nil {
+		cmd.Warnf("checking for dependency cycles: %s", err)
+		return
+	}
+	if cycle == nil {
+		return
+	}
+	var others []string
+	for _, r := range cycle.Repos {
+		if r != repo {
+			others = append(others, r.String())
+		}
+	}
+	cmd.Warnf("%s is in a dependency cycle with %s; see \"rx cycles\"", repo, strings.Join(others, ", "))
+}
 
-	fallback = ""
+// cascadeUsers returns all repositories which transitively depend on repo,
+// including through external tests, ordered such that each comes after the
+// repositories it depends upon.
+func cascadeUsers(repo *graph.Repository) ([]*graph.Repository, error) {
+	users, err := Deps.TransitiveRepoUsers(repo, graph.AllEdges, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Deps.SortRepos(users)
 }
 
 func init() {
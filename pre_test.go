@@ -0,0 +1,117 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+// testGraph builds a graph with one single-package repository per key of
+// imports, where imports[a] lists the repositories a imports.
+func testGraph(imports map[string][]string) *graph.Graph {
+	g := graph.New()
+	for name := range imports {
+		g.Repository[name] = &graph.Repository{Root: name, VCS: "git", Packages: []string{name}}
+		g.Package[name] = &graph.Package{ImportPath: name, Name: name, RepoRoot: name}
+	}
+	for name, deps := range imports {
+		for _, dep := range deps {
+			if g.DependsOn[name] == nil {
+				g.DependsOn[name] = map[string]bool{}
+			}
+			if g.UsedBy[dep] == nil {
+				g.UsedBy[dep] = map[string]bool{}
+			}
+			g.DependsOn[name][dep] = true
+			g.UsedBy[dep][name] = true
+		}
+	}
+	return g
+}
+
+func TestCascadeUsers(t *testing.T) {
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = testGraph(map[string][]string{
+		"base":   nil,
+		"util":   {"base"},
+		"app":    {"util", "web"},
+		"web":    {"base", "util"},
+		"other":  nil,
+		"client": {"other"},
+	})
+
+	users, err := cascadeUsers(Deps.Repository["base"])
+	if err != nil {
+		t.Fatalf("cascadeUsers: %s", err)
+	}
+	var got []string
+	for _, repo := range users {
+		got = append(got, repo.Root)
+	}
+	if want := []string{"util", "web", "app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cascadeUsers(base) = %q, want %q", got, want)
+	}
+}
+
+func TestPrescribeRollbackBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+
+	tmp, err := ioutil.TempDir("", "rx-rollback-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "repo")
+	os.Mkdir(dir, 0755)
+	gitRun(t, dir, "init", "-q")
+	gitRun(t, dir, "symbolic-ref", "HEAD", "refs/heads/work")
+	gitCommit(t, dir, "a.go", "package a\n")
+	gitRun(t, dir, "tag", "v1")
+	gitCommit(t, dir, "b.go", "package a\n")
+
+	// The package is unknown to the graph, so the build is sure to fail
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	repo := &graph.Repository{
+		Root:     dir,
+		VCS:      "git",
+		Packages: []string{"example.com/a"},
+	}
+	Deps.Repository[dir] = repo
+
+	fs := flag.NewFlagSet("prescribe", flag.ContinueOnError)
+	p := newPipeline(fs)
+	if err := fs.Parse([]string{"--test=false", "--install=false", "--cascade=false"}); err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if err := p.prescribe(preCmd, repo, "v1"); err == nil {
+		t.Fatalf("prescribe of unbuildable repo succeeded")
+	}
+
+	if branch, err := repo.Branch(); err != nil || branch != "work" {
+		t.Errorf("branch after rollback = %q, %v; want %q", branch, err, "work")
+	}
+}
@@ -27,6 +27,15 @@ information about its tags.  The <repo> can be a full repository path, the last
 element of a repository path, or any substring of the path as long as it is
 unique.
 
+Tags which are semantic versions (such as "v1.2.3", "1.2" or "go1.2rc1") can be
+ordered by version with --sort, restricted to a range of versions with --match
+(for example --match=">=1.2 <2" or --match="^1.2"), or narrowed down to the
+newest version with --latest.  Prereleases are left out by --match and --latest
+unless --pre is specified.
+
+Unless -f is given, any go.mod requirements by other repositories which the
+checked-out revision does not satisfy are listed after the tags.
+
 The -f option takes a template as a format.  The data passed into the
 template invocation is an (rx/graph) TagList, and the default format is:
 
@@ -38,6 +47,10 @@ var (
 	tagsLong   = tagsCmd.Flag.Bool("long", false, "Use long output format")
 	tagsUp     = tagsCmd.Flag.Bool("up", false, "Only show updates (overrides --down)")
 	tagsDown   = tagsCmd.Flag.Bool("down", false, "Only show downgrades")
+	tagsSort   = tagsCmd.Flag.Bool("sort", false, "Sort by semantic version, newest first")
+	tagsMatch  = tagsCmd.Flag.String("match", "", "Only show versions satisfying this constraint")
+	tagsLatest = tagsCmd.Flag.Bool("latest", false, "Only show the newest version")
+	tagsPre    = tagsCmd.Flag.Bool("pre", false, "Include prereleases in --match and --latest")
 )
 
 func tagsFunc(cmd *Command, args ...string) {
@@ -68,11 +81,35 @@ func tagsFunc(cmd *Command, args ...string) {
 		cmd.Fatalf("list tags for %q: %s", repo.Root, err)
 	}
 
+	if *tagsMatch != "" {
+		c, err := graph.ParseConstraint(*tagsMatch)
+		if err != nil {
+			cmd.BadArgs("--match: %s", err)
+		}
+		tags = tags.Match(c, *tagsPre)
+	}
+	if *tagsLatest {
+		latest, ok := tags.Latest(*tagsPre)
+		if !ok {
+			cmd.Fatalf("no versions found for %q", repo.Root)
+		}
+		tags = graph.TagList{latest}
+	}
+	if *tagsSort {
+		tags.SortVersions()
+	}
+
 	switch {
 	case *tagsFormat != "":
 		render(stdout, *tagsFormat, tags)
 	default:
 		render(stdout, tagsTemplate, tags)
+
+		mismatches, err := Deps.Mismatches(repo)
+		if err != nil {
+			cmd.Fatalf("check requirements for %q: %s", repo.Root, err)
+		}
+		render(stdout, tagsMismatchTemplate, mismatches)
 	}
 }
 
@@ -83,4 +120,9 @@ func init() {
 var (
 	tagsTemplate = `{{range .}}{{.Rev}} {{.Name}}
 {{end}}`
+
+	tagsMismatchTemplate = `{{if .}}
+The checked-out revision does not match these requirements:
+{{range .}}  {{.}}
+{{end}}{{end}}`
 )
@@ -0,0 +1,54 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"kylelemons.net/go/rx/vcs"
+)
+
+// toolsFile is the name of the file within $RX_DIR which defines additional
+// version control tools.
+const toolsFile = "vcs.json"
+
+// LoadTools registers the version control tools defined in the tools file,
+// replacing any built-in drivers with the same name.  It is not an error for
+// the file to be missing.
+func LoadTools() error {
+	filename := filepath.Join(expandRxDir(), toolsFile)
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load tools: %s", err)
+	}
+	defer file.Close()
+
+	drivers, err := vcs.ReadTools(file)
+	if err != nil {
+		return fmt.Errorf("load tools from %q: %s", filename, err)
+	}
+	for _, d := range drivers {
+		if _, err := vcs.Lookup(d.Name()); err == nil {
+			log.Printf("Overriding built-in vcs %q", d.Name())
+		}
+		vcs.Register(d)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kylelemons.net/go/rx/vcs"
+)
+
+func TestLoadTools(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rx-tools-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(old string) { *rxDir = old }(*rxDir)
+	*rxDir = tmp
+	defer vcs.Unregister("rxtest")
+
+	// No file is fine
+	if err := LoadTools(); err != nil {
+		t.Errorf("load with no file: %s", err)
+	}
+
+	filename := filepath.Join(tmp, toolsFile)
+	ioutil.WriteFile(filename, []byte(`{"rxtest": {"Command": "rxtest"}}`), 0644)
+	if err := LoadTools(); err == nil {
+		t.Errorf("load of invalid tool succeeded")
+	}
+	if _, err := vcs.Lookup("rxtest"); err == nil {
+		t.Errorf("invalid tool was registered")
+	}
+
+	ioutil.WriteFile(filename, []byte(`{"rxtest": {
+		"Command": "rxtest",
+		"RootDir": ["root"],
+		"ToRev":   ["update", "{{.}}"],
+		"Current": ["id"]
+	}}`), 0644)
+	if err := LoadTools(); err != nil {
+		t.Errorf("load: %s", err)
+	}
+	if _, err := vcs.Lookup("rxtest"); err != nil {
+		t.Errorf("tool was not registered: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+var updateCmd = &Command{
+	Name:    "update",
+	Usage:   "<repo>",
+	Summary: "Update the repository to its newest tag.",
+	Help: `The update command moves the repository to the newest tag for which its
+current revision is an ancestor.  Only tags which are stable semantic versions
+(see "rx help tags") are considered, so branches and other tags are ignored; a
+repository with no newer version tag is reported and left alone.  The <repo>
+can be a full repository path, the last element of a repository path, or any
+substring as long as it is unique.
+
+After updating, the repository is tested, built, and installed exactly as it
+would be by the prescribe command, and it is rolled back if any of those steps
+fail.  The same options are available to control this behavior.
+
+If --all is specified, no <repo> may be given and every repository is updated
+in dependency order, so that each repository is updated after the repositories
+upon which it depends.  A failure to update one repository does not prevent
+the others from being updated.`,
+}
+
+var (
+	updatePipeline = newPipeline(&updateCmd.Flag)
+	updateAll      = updateCmd.Flag.Bool("all", false, "update all repositories in dependency order")
+)
+
+func updateFunc(cmd *Command, args ...string) {
+	var repos []*graph.Repository
+	switch {
+	case *updateAll && len(args) > 0:
+		cmd.BadArgs("<repo> may not be specified with --all")
+	case *updateAll:
+		for _, repo := range Deps.Repository {
+			repos = append(repos, repo)
+		}
+		var err error
+		repos, err = Deps.SortRepos(repos)
+		if err != nil {
+			cmd.Fatalf("sort: %s", err)
+		}
+	case len(args) == 1:
+		repo, err := Deps.FindRepo(args[0])
+		if err != nil {
+			cmd.Fatalf("<repo>: %s", err)
+		}
+		repos = append(repos, repo)
+	default:
+		cmd.BadArgs("requires one argument")
+	}
+
+	for _, repo := range repos {
+		tag, err := updateRepo(cmd, repo)
+		switch {
+		case err != nil:
+			cmd.Errorf("%s: %s", repo, err)
+		case tag != nil:
+			fmt.Fprintf(stdout, "%s: updated to %s (%s)\n", repo, tag.Name, tag.Rev)
+		default:
+			fmt.Fprintf(stdout, "%s: no newer version tag\n", repo)
+		}
+	}
+}
+
+// updateRepo prescribes the newest stable version tag among the upgrades for
+// repo.  It returns the tag that was applied, or nil if there is no newer
+// version tag.
+func updateRepo(cmd *Command, repo *graph.Repository) (*graph.Tag, error) {
+	tags, err := repo.Upgrades()
+	if err != nil {
+		return nil, err
+	}
+
+	// Upgrades include branches (such as origin/master after a fetch), so
+	// pick by version rather than taking the first one listed.
+	newest, ok := tags.Latest(false)
+	if !ok {
+		return nil, nil
+	}
+	log.Printf("Updating %s to %s", repo, newest.Name)
+	if err := updatePipeline.prescribe(cmd, repo, newest.Rev); err != nil {
+		return nil, err
+	}
+	return &newest, nil
+}
+
+func init() {
+	updateCmd.Run = updateFunc
+}
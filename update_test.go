@@ -0,0 +1,84 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestUpdate(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+
+	tmp, err := ioutil.TempDir("", "rx-update-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "repo")
+	os.Mkdir(dir, 0755)
+	gitRun(t, dir, "init", "-q")
+	gitCommit(t, dir, "a.go", "package a\n")
+	gitRun(t, dir, "tag", "v1")
+	gitCommit(t, dir, "b.go", "package a\n")
+	gitRun(t, dir, "tag", "v2")
+	gitCommit(t, dir, "c.go", "package a\n")
+	gitRun(t, dir, "tag", "v3")
+	want := gitRun(t, dir, "rev-parse", "HEAD")
+	// A branch beyond the newest tag is listed first, but is not a version
+	gitCommit(t, dir, "d.go", "package a\n")
+	gitRun(t, dir, "branch", "next")
+	gitRun(t, dir, "checkout", "-q", "v1")
+
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	Deps = graph.New()
+	Deps.Repository[dir] = &graph.Repository{
+		Root:     dir,
+		VCS:      "git",
+		Packages: []string{"example.com/a"},
+	}
+
+	buf := new(bytes.Buffer)
+	defer func(old io.Writer) { stdout = old }(stdout)
+	stdout = buf
+	updateCmd.Exec([]string{"--build=false", "--test=false", "--install=false", "repo"})
+
+	if got := gitRun(t, dir, "rev-parse", "HEAD"); got != want {
+		t.Errorf("head after update = %q, want %q (v3)", got, want)
+	}
+	if out := buf.String(); !strings.Contains(out, "updated to v3") {
+		t.Errorf("update output does not report the update:\n%s", out)
+	}
+
+	buf.Reset()
+	updateCmd.Exec([]string{"--build=false", "--test=false", "--install=false", "repo"})
+	if got := gitRun(t, dir, "rev-parse", "HEAD"); got != want {
+		t.Errorf("head after second update = %q, want %q (v3)", got, want)
+	}
+	if out := buf.String(); !strings.Contains(out, "no newer version tag") {
+		t.Errorf("update output does not report that there is no newer tag:\n%s", out)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bzrDriver is the Driver for Bazaar.
+type bzrDriver struct{}
+
+func (bzrDriver) Name() string { return "bzr" }
+
+func (bzrDriver) Root(dir string) (string, error) {
+	// The closest .bzr is the working tree, even within a shared repository
+	return findMarker(dir, ".bzr")
+}
+
+func (bzrDriver) Head(root string) (string, error) {
+	// The output is "<revno> <revid>"
+	out, err := run(root, "bzr", "revision-info", "--tree")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("bzr: unexpected revision-info %q", out)
+	}
+	return fields[1], nil
+}
+
+func (bzrDriver) Checkout(root, rev string) error {
+	// Bazaar tries revision numbers, revision IDs and tags for bare revisions.
+	_, err := run(root, "bzr", "update", "-r", rev)
+	return err
+}
+
+func (d bzrDriver) Tags(root string) ([]Tag, error) {
+	_, down, err := d.tags(root)
+	return down, err
+}
+
+func (d bzrDriver) Updates(root string) ([]Tag, error) {
+	up, _, err := d.tags(root)
+	return up, err
+}
+
+// tags lists the tags on the branch's mainline which come after (up) and at or
+// before (down) the working tree's revision.  Tags on merged revisions, which
+// have dotted revision numbers, are not listed.
+func (bzrDriver) tags(root string) (up, down []Tag, err error) {
+	out, err := run(root, "bzr", "revno", "--tree")
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bzr: unexpected revno %q", out)
+	}
+
+	// Each line of output is "<tag> <revno>" or "<tag> <revid>"
+	numbered, err := run(root, "bzr", "tags")
+	if err != nil {
+		return nil, nil, err
+	}
+	byID, err := run(root, "bzr", "tags", "--show-ids")
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := map[string]string{}
+	for _, line := range strings.Split(byID, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			ids[fields[0]] = fields[1]
+		}
+	}
+
+	var all []revnoTag
+	for _, line := range strings.Split(numbered, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		revno, err := strconv.Atoi(fields[1])
+		if err != nil {
+			// Dotted revnos and "?" (not in this branch)
+			continue
+		}
+		all = append(all, revnoTag{Tag{fields[0], ids[fields[0]]}, revno})
+	}
+	sort.Stable(byRevno(all))
+
+	for _, t := range all {
+		if t.revno > current {
+			up = append(up, t.Tag)
+		} else {
+			down = append(down, t.Tag)
+		}
+	}
+	return up, down, nil
+}
+
+// A revnoTag is a tag along with its mainline revision number.
+type revnoTag struct {
+	Tag
+	revno int
+}
+
+// byRevno sorts tags newest first.
+type byRevno []revnoTag
+
+func (b byRevno) Len() int           { return len(b) }
+func (b byRevno) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byRevno) Less(i, j int) bool { return b[i].revno > b[j].revno }
+
+func (bzrDriver) Dirty(root string) (bool, error) {
+	out, err := run(root, "bzr", "status", "--short", "--versioned")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (bzrDriver) Stash(root string) error {
+	_, err := run(root, "bzr", "shelve", "--quiet", "--all", "-m", "rx auto-stash")
+	return err
+}
+
+func (bzrDriver) Unstash(root string) error {
+	_, err := run(root, "bzr", "unshelve", "--quiet")
+	return err
+}
+
+func (bzrDriver) Fetch(root string) error {
+	// "bzr pull" always updates the working tree along with the branch.
+	return ErrUnsupported
+}
@@ -0,0 +1,279 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kylelemons.net/go/rx/vcs/gitdir"
+)
+
+// gitDriver is the Driver for git.  Read-only queries are answered by reading
+// the repository directly when possible, falling back to the git command.
+type gitDriver struct{}
+
+func (gitDriver) Name() string { return "git" }
+
+func (gitDriver) Root(dir string) (string, error) {
+	return gitdir.FindRoot(dir)
+}
+
+func (gitDriver) Head(root string) (string, error) {
+	if repo, err := gitdir.Open(root); err == nil {
+		defer repo.Close()
+		if head, err := repo.Head(); err == nil {
+			return head.String(), nil
+		}
+	}
+	out, err := run(root, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (gitDriver) Checkout(root, rev string) error {
+	_, err := run(root, "git", "checkout", rev)
+	return err
+}
+
+// gitLogRegex parses the output of git log --pretty=format:%H%d
+var gitLogRegex = regexp.MustCompile(`^([a-z0-9]+) \((.*)\)`)
+
+func (gitDriver) Tags(root string) ([]Tag, error) {
+	if tags, err := gitTags(root, false, true); err == nil {
+		return tags, nil
+	}
+	out, err := run(root, "git", "log", "--pretty=format:%H%d", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(out, gitLogRegex), nil
+}
+
+func (gitDriver) Updates(root string) ([]Tag, error) {
+	if tags, err := gitTags(root, true, false); err == nil {
+		return tags, nil
+	}
+	out, err := run(root, "git", "log", "--pretty=format:%H%d", "--all", "^HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(out, gitLogRegex), nil
+}
+
+func (gitDriver) Dirty(root string) (bool, error) {
+	out, err := run(root, "git", "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (gitDriver) Branch(root string) (string, error) {
+	if repo, err := gitdir.Open(root); err == nil {
+		defer repo.Close()
+		if ref, err := repo.HeadRef(); err == nil {
+			return strings.TrimPrefix(ref, "refs/heads/"), nil
+		}
+	}
+	// symbolic-ref fails when HEAD is detached, so ask it not to
+	out, err := run(root, "git", "symbolic-ref", "-q", "--short", "HEAD")
+	if err != nil {
+		if _, herr := run(root, "git", "rev-parse", "HEAD"); herr == nil {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (gitDriver) BranchRev(root, branch string) (string, error) {
+	if repo, err := gitdir.Open(root); err == nil {
+		defer repo.Close()
+		if h, err := repo.Resolve("refs/heads/" + branch); err == nil {
+			return h.String(), nil
+		}
+	}
+	out, err := run(root, "git", "rev-parse", "--verify", "refs/heads/"+branch)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (gitDriver) SetBranch(root, branch, rev string) error {
+	_, err := run(root, "git", "checkout", "-q", "-B", branch, rev)
+	return err
+}
+
+func (gitDriver) Stash(root string) error {
+	_, err := run(root, "git", "stash", "push", "-q", "-m", "rx auto-stash")
+	return err
+}
+
+func (gitDriver) Unstash(root string) error {
+	_, err := run(root, "git", "stash", "pop", "-q")
+	return err
+}
+
+func (gitDriver) Count(root, from, to string) (int, error) {
+	out, err := run(root, "git", "rev-list", "--count", from+".."+to)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// gitTagRegex parses the output of git log --pretty=format:"%H %D" --decorate=full
+var gitTagRegex = regexp.MustCompile(`^([a-z0-9]+) (.*)`)
+
+func (gitDriver) TagsBetween(root, from, to string) ([]Tag, error) {
+	if tags, err := gitTagsBetween(root, from, to); err == nil {
+		return tags, nil
+	}
+	out, err := run(root, "git", "log", "--pretty=format:%H %D", "--decorate=full", from+".."+to)
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, tag := range parseTags(out, gitTagRegex) {
+		if strings.HasPrefix(tag.Name, "refs/tags/") {
+			tag.Name = strings.TrimPrefix(tag.Name, "refs/tags/")
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (gitDriver) HasRev(root, rev string) (bool, error) {
+	// With --quiet, a missing revision is the only failure with no message.
+	cmd := exec.Command("git", "rev-parse", "--quiet", "--verify", rev+"^{commit}")
+	cmd.Dir = root
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 {
+			return false, nil
+		}
+		return false, fmt.Errorf("git rev-parse --verify %s: %s: %s", rev, err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+func (gitDriver) Fetch(root string) error {
+	_, err := run(root, "git", "fetch", "--tags")
+	return err
+}
+
+// gitTagsBetween lists the tags on commits reachable from to but not from by
+// reading the repository directly.
+func gitTagsBetween(root, from, to string) ([]Tag, error) {
+	repo, err := gitdir.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	var ancestors [2]map[gitdir.Hash]bool
+	for i, rev := range []string{from, to} {
+		h, err := repo.Resolve(rev)
+		if err != nil {
+			return nil, err
+		}
+		if h, err = repo.Peel(h); err != nil {
+			return nil, err
+		}
+		if ancestors[i], err = repo.Ancestors(h); err != nil {
+			return nil, err
+		}
+	}
+	decs, err := repo.Decorations()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []Tag
+	for _, dec := range decs {
+		if ancestors[0][dec.Hash] || !ancestors[1][dec.Hash] {
+			continue
+		}
+		for _, name := range dec.Tags() {
+			tags = append(tags, Tag{
+				Name: name,
+				Rev:  dec.Hash.String(),
+			})
+		}
+	}
+	return tags, nil
+}
+
+// gitTags lists the tags for which HEAD is an ancestor (up) and/or the tags
+// which are ancestors of HEAD (down) by reading the repository directly.
+func gitTags(root string, up, down bool) ([]Tag, error) {
+	repo, err := gitdir.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := repo.Ancestors(head)
+	if err != nil {
+		return nil, err
+	}
+	decs, err := repo.Decorations()
+	if err != nil {
+		return nil, err
+	}
+
+	var ups, downs []Tag
+	for _, dec := range decs {
+		var list *[]Tag
+		switch {
+		case ancestors[dec.Hash]:
+			list = &downs
+		case up:
+			isUp, err := repo.IsAncestor(head, ancestors, dec.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if !isUp {
+				continue
+			}
+			list = &ups
+		default:
+			continue
+		}
+		for _, name := range dec.Names {
+			*list = append(*list, Tag{
+				Name: name,
+				Rev:  dec.Hash.String(),
+			})
+		}
+	}
+	if !down {
+		downs = nil
+	}
+	return append(ups, downs...), nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitdir
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeelDepth is the number of nested tag objects that will be followed.
+const maxPeelDepth = 10
+
+// A Commit is the parsed form of a commit object.
+type Commit struct {
+	Hash    Hash
+	Parents []Hash
+	Time    time.Time // committer time
+}
+
+// Commit reads and parses the named commit.
+func (r *Repo) Commit(h Hash) (*Commit, error) {
+	if c, ok := r.commits[h]; ok {
+		return c, nil
+	}
+	kind, data, err := r.object(h)
+	if err != nil {
+		return nil, err
+	}
+	if kind != objCommit {
+		return nil, notCommit(h)
+	}
+
+	c := &Commit{Hash: h}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			// The headers end at the first blank line
+			break
+		}
+		sp := bytes.IndexByte(line, ' ')
+		if sp < 0 {
+			continue
+		}
+		key, val := string(line[:sp]), line[sp+1:]
+		switch key {
+		case "parent":
+			if r.shallow[h] {
+				continue
+			}
+			p, err := ParseHash(string(val))
+			if err != nil {
+				return nil, err
+			}
+			c.Parents = append(c.Parents, p)
+		case "committer":
+			// The committer line ends with "<unix time> <zone>"
+			fields := bytes.Fields(val)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("gitdir: commit %s: malformed committer", h)
+			}
+			sec, err := strconv.ParseInt(string(fields[len(fields)-2]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("gitdir: commit %s: malformed committer time", h)
+			}
+			c.Time = time.Unix(sec, 0)
+		}
+	}
+	r.commits[h] = c
+	return c, nil
+}
+
+// notCommit is the error returned by Commit for objects of other types.
+type notCommit Hash
+
+func (e notCommit) Error() string {
+	return fmt.Sprintf("gitdir: %s is not a commit", Hash(e))
+}
+
+// Peel follows annotated tags until it finds a non-tag object.
+func (r *Repo) Peel(h Hash) (Hash, error) {
+	for i := 0; i < maxPeelDepth; i++ {
+		if _, ok := r.commits[h]; ok {
+			return h, nil
+		}
+		kind, data, err := r.object(h)
+		if err != nil {
+			return h, err
+		}
+		if kind != objTag {
+			return h, nil
+		}
+		// The target is in the "object" header, which comes first
+		if !bytes.HasPrefix(data, []byte("object ")) || len(data) < 7+40 {
+			return h, fmt.Errorf("gitdir: tag %s: malformed", h)
+		}
+		if h, err = ParseHash(string(data[7 : 7+40])); err != nil {
+			return h, err
+		}
+	}
+	return h, fmt.Errorf("gitdir: tag %s: nested too deeply", h)
+}
+
+// Ancestors returns the set of commits reachable from h, including h itself.
+func (r *Repo) Ancestors(h Hash) (map[Hash]bool, error) {
+	seen := map[Hash]bool{h: true}
+	for queue := []Hash{h}; len(queue) > 0; queue = queue[1:] {
+		c, err := r.Commit(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return seen, nil
+}
+
+// IsAncestor returns true if a is b or is reachable from b.  The ancestors
+// of a, as returned by Ancestors, are used to prune the search.
+func (r *Repo) IsAncestor(a Hash, ancestors map[Hash]bool, b Hash) (bool, error) {
+	seen := map[Hash]bool{b: true}
+	for queue := []Hash{b}; len(queue) > 0; queue = queue[1:] {
+		h := queue[0]
+		if h == a {
+			return true, nil
+		}
+		// None of a's ancestors can lead back to a
+		if ancestors[h] {
+			continue
+		}
+		c, err := r.Commit(h)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return false, nil
+}
+
+// A Decoration is a commit named by one or more tags or branches.
+type Decoration struct {
+	*Commit
+	Names []string // short names, as from ShortName: tags, then branches, then remotes
+
+	refs []string // full names of the refs
+	gen  int      // generation number, used to order commits with equal times
+}
+
+// Tags returns the short names of the tags among the decoration's refs.
+func (d *Decoration) Tags() []string {
+	var tags []string
+	for _, ref := range d.refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+	return tags
+}
+
+// Decorations returns every commit named by a tag, branch or remote branch,
+// newest first.
+func (r *Repo) Decorations() ([]*Decoration, error) {
+	refs, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	byHash := map[Hash]*Decoration{}
+	for ref, h := range refs {
+		if _, ok := ShortName(ref); !ok {
+			continue
+		}
+		h, err := r.Peel(h)
+		if err != nil {
+			return nil, err
+		}
+		c, err := r.Commit(h)
+		if _, ok := err.(notCommit); ok {
+			// Tags can point at trees and blobs; those aren't interesting
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		d, ok := byHash[h]
+		if !ok {
+			gen, err := r.generation(h)
+			if err != nil {
+				return nil, err
+			}
+			d = &Decoration{Commit: c, gen: gen}
+			byHash[h] = d
+		}
+		d.refs = append(d.refs, ref)
+	}
+
+	decs := make([]*Decoration, 0, len(byHash))
+	for _, d := range byHash {
+		sort.Sort(byRank(d.refs))
+		for _, ref := range d.refs {
+			name, _ := ShortName(ref)
+			d.Names = append(d.Names, name)
+		}
+		decs = append(decs, d)
+	}
+	sort.Sort(byTime(decs))
+	return decs, nil
+}
+
+// generation returns the length of the longest path from h to a root commit,
+// which is always larger for a commit than for any of its ancestors.
+func (r *Repo) generation(h Hash) (int, error) {
+	if r.gens == nil {
+		r.gens = map[Hash]int{}
+	}
+	stack := []Hash{h}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if _, ok := r.gens[top]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		c, err := r.Commit(top)
+		if err != nil {
+			return 0, err
+		}
+		// Compute the parents first, then come back to this commit
+		gen, ready := 0, true
+		for _, p := range c.Parents {
+			pg, ok := r.gens[p]
+			if !ok {
+				stack = append(stack, p)
+				ready = false
+				continue
+			}
+			if pg+1 > gen {
+				gen = pg + 1
+			}
+		}
+		if ready {
+			r.gens[top] = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return r.gens[h], nil
+}
+
+// byTime sorts decorations newest first, breaking ties by topology and then
+// by hash.
+type byTime []*Decoration
+
+func (b byTime) Len() int      { return len(b) }
+func (b byTime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool {
+	if ti, tj := b[i].Time, b[j].Time; !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	if gi, gj := b[i].gen, b[j].gen; gi != gj {
+		return gi > gj
+	}
+	return bytes.Compare(b[i].Hash[:], b[j].Hash[:]) < 0
+}
+
+// byRank sorts ref names in the order their namespaces appear in decorated.
+type byRank []string
+
+func (b byRank) Len() int      { return len(b) }
+func (b byRank) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byRank) Less(i, j int) bool {
+	ri, ni := refRank(b[i])
+	rj, nj := refRank(b[j])
+	if ri != rj {
+		return ri < rj
+	}
+	return ni < nj
+}
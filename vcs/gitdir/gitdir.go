@@ -0,0 +1,223 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gitdir reads git repositories directly from their .git directory.
+//
+// It understands loose objects, packfiles (including deltified objects),
+// loose and packed refs, linked worktrees, alternates and shallow clones,
+// which is enough to answer read-only questions like "what is HEAD" and
+// "which tags contain this commit" without forking the git binary.  Anything
+// else (reftables, SHA-256 repositories, etc.) results in an error, and the
+// caller is expected to fall back to the git command.
+package gitdir
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Hash is the SHA-1 name of a git object.
+type Hash [20]byte
+
+// ParseHash parses a full 40-character hexadecimal object name.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	if len(s) != 2*len(h) {
+		return h, fmt.Errorf("gitdir: bad object name %q", s)
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, fmt.Errorf("gitdir: bad object name %q: %s", s, err)
+	}
+	return h, nil
+}
+
+// String returns the hexadecimal form of the hash.
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// A Repo is an open git repository.  A Repo caches the objects it parses, and
+// should not be used after the repository on disk has been modified.
+type Repo struct {
+	Root   string // top-level directory of the working tree
+	GitDir string // the .git directory (per-worktree state like HEAD)
+	Common string // the directory containing objects and refs
+
+	objdirs []*objdir        // object directories, including alternates
+	shallow map[Hash]bool    // commits whose parents are not present
+	commits map[Hash]*Commit // parsed commits
+	gens    map[Hash]int     // generation numbers of commits
+	refs    map[string]Hash  // cached result of Refs
+}
+
+// FindRoot returns the top-level directory of the working tree containing
+// dir, which is the closest ancestor (or dir itself) containing a ".git".
+func FindRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("gitdir: no .git found")
+		}
+		dir = parent
+	}
+}
+
+// Open opens the repository whose working tree contains dir.
+func Open(dir string) (*Repo, error) {
+	root, err := FindRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{
+		Root:    root,
+		GitDir:  filepath.Join(root, ".git"),
+		shallow: map[Hash]bool{},
+		commits: map[Hash]*Commit{},
+	}
+
+	// Linked worktrees and submodules have a .git file pointing elsewhere
+	if fi, err := os.Stat(r.GitDir); err == nil && !fi.IsDir() {
+		b, err := ioutil.ReadFile(r.GitDir)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.TrimSpace(string(b))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return nil, fmt.Errorf("gitdir: unrecognized .git file in %q", root)
+		}
+		r.GitDir = r.rel(root, strings.TrimSpace(strings.TrimPrefix(line, "gitdir:")))
+	}
+	r.Common = r.GitDir
+	if b, err := ioutil.ReadFile(filepath.Join(r.GitDir, "commondir")); err == nil {
+		r.Common = r.rel(r.GitDir, strings.TrimSpace(string(b)))
+	}
+
+	if err := r.checkFormat(); err != nil {
+		return nil, err
+	}
+
+	// Find object directories
+	objects := filepath.Join(r.Common, "objects")
+	if err := r.addObjdir(objects, 0); err != nil {
+		return nil, err
+	}
+
+	// Shallow clones are missing the parents of some commits
+	if b, err := ioutil.ReadFile(filepath.Join(r.Common, "shallow")); err == nil {
+		for _, line := range strings.Fields(string(b)) {
+			h, err := ParseHash(line)
+			if err != nil {
+				return nil, err
+			}
+			r.shallow[h] = true
+		}
+	}
+	return r, nil
+}
+
+// rel resolves path relative to base if it is not absolute.
+func (r *Repo) rel(base, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// checkFormat returns an error if the repository uses a format not understood
+// by this package.
+func (r *Repo) checkFormat() error {
+	if _, err := os.Stat(filepath.Join(r.Common, "reftable")); err == nil {
+		return fmt.Errorf("gitdir: reftable repositories are not supported")
+	}
+	f, err := os.Open(filepath.Join(r.Common, "config"))
+	if err != nil {
+		return fmt.Errorf("gitdir: %s", err)
+	}
+	defer f.Close()
+
+	// A tiny subset of the config format is enough to find extensions
+	var section string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[] \t"))
+			continue
+		}
+		if section != "extensions" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		var val string
+		if len(kv) == 2 {
+			val = strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+		switch {
+		case key == "objectformat" && val != "sha1":
+			return fmt.Errorf("gitdir: object format %q is not supported", val)
+		case key == "refstorage" && val != "files":
+			return fmt.Errorf("gitdir: ref storage %q is not supported", val)
+		}
+	}
+	return scan.Err()
+}
+
+// addObjdir adds an object directory and (recursively) its alternates.
+func (r *Repo) addObjdir(dir string, depth int) error {
+	if depth > 5 {
+		return fmt.Errorf("gitdir: alternates nested too deeply at %q", dir)
+	}
+	od, err := openObjdir(dir)
+	if err != nil {
+		return err
+	}
+	r.objdirs = append(r.objdirs, od)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		alt := strings.TrimSpace(string(line))
+		if alt == "" || strings.HasPrefix(alt, "#") {
+			continue
+		}
+		if err := r.addObjdir(r.rel(dir, alt), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the packfiles held open by the repository.
+func (r *Repo) Close() error {
+	for _, od := range r.objdirs {
+		od.close()
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitdir
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=rx", "GIT_AUTHOR_EMAIL=rx@localhost",
+		"GIT_COMMITTER_NAME=rx", "GIT_COMMITTER_EMAIL=rx@localhost",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testRepo creates a repository with some history, branches and tags, and
+// returns its path.  The caller should remove it.
+func testRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not found: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "rx-gitdir-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	git(t, dir, "init", "-q")
+	git(t, dir, "symbolic-ref", "HEAD", "refs/heads/master")
+
+	// A file that changes a little at a time gives gc something to deltify
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("line %d of a moderately long file", i))
+	}
+	for i := 0; i < 6; i++ {
+		lines[i*10] = fmt.Sprintf("changed in commit %d", i)
+		body := strings.Join(lines, "\n")
+		if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte(body), 0644); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+		git(t, dir, "add", "file.txt")
+		git(t, dir, "commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+		switch i {
+		case 1:
+			git(t, dir, "tag", "v1")
+		case 2:
+			git(t, dir, "tag", "-a", "-m", "annotated", "v2")
+		case 3:
+			git(t, dir, "branch", "stable")
+		}
+	}
+	git(t, dir, "tag", "v3")
+
+	// A side branch with a merge
+	git(t, dir, "checkout", "-q", "-b", "side", "v2")
+	ioutil.WriteFile(filepath.Join(dir, "side.txt"), []byte("side\n"), 0644)
+	git(t, dir, "add", "side.txt")
+	git(t, dir, "commit", "-q", "-m", "side")
+	git(t, dir, "tag", "side-1")
+	git(t, dir, "checkout", "-q", "master")
+	git(t, dir, "merge", "-q", "--no-edit", "side")
+	return dir
+}
+
+func checkRepo(t *testing.T, desc, dir string) {
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatalf("%s: open: %s", desc, err)
+	}
+	defer r.Close()
+
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("%s: head: %s", desc, err)
+	}
+	if got, want := head.String(), git(t, dir, "rev-parse", "HEAD"); got != want {
+		t.Errorf("%s: head = %s, want %s", desc, got, want)
+	}
+	if ref, err := r.HeadRef(); err != nil || ref != "refs/heads/master" {
+		t.Errorf("%s: headref = %q, %v; want refs/heads/master", desc, ref, err)
+	}
+
+	// Refs should match show-ref
+	refs, err := r.Refs()
+	if err != nil {
+		t.Fatalf("%s: refs: %s", desc, err)
+	}
+	var got []string
+	for name, h := range refs {
+		got = append(got, h.String()+" "+name)
+	}
+	sort.Strings(got)
+	want := strings.Split(git(t, dir, "show-ref"), "\n")
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: refs = %q, want %q", desc, got, want)
+	}
+
+	// Ancestors should match rev-list
+	anc, err := r.Ancestors(head)
+	if err != nil {
+		t.Fatalf("%s: ancestors: %s", desc, err)
+	}
+	got = nil
+	for h := range anc {
+		got = append(got, h.String())
+	}
+	sort.Strings(got)
+	want = strings.Split(git(t, dir, "rev-list", "HEAD"), "\n")
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: ancestors = %q, want %q", desc, got, want)
+	}
+
+	// Every object (including deltified ones) should match cat-file
+	for _, line := range strings.Split(git(t, dir, "rev-list", "--objects", "--all"), "\n") {
+		name := strings.Fields(line)[0]
+		h, err := ParseHash(name)
+		if err != nil {
+			t.Fatalf("%s: %s", desc, err)
+		}
+		_, data, err := r.object(h)
+		if err != nil {
+			t.Errorf("%s: object %s: %s", desc, name, err)
+			continue
+		}
+		cmd := exec.Command("git", "cat-file", "--batch")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader(name + "\n")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: cat-file: %s", desc, err)
+		}
+		// Output is "<name> <type> <size>\n<contents>\n"
+		out = out[bytes.IndexByte(out, '\n')+1 : len(out)-1]
+		if !bytes.Equal(data, out) {
+			t.Errorf("%s: object %s differs from cat-file", desc, name)
+		}
+	}
+
+	// Check decorations and ancestry queries
+	v2, err := r.Resolve("v2")
+	if err != nil {
+		t.Fatalf("%s: resolve v2: %s", desc, err)
+	}
+	if v2, err = r.Peel(v2); err != nil {
+		t.Fatalf("%s: peel v2: %s", desc, err)
+	}
+	if got, want := v2.String(), git(t, dir, "rev-parse", "v2^{commit}"); got != want {
+		t.Errorf("%s: peel(v2) = %s, want %s", desc, got, want)
+	}
+	v2anc, err := r.Ancestors(v2)
+	if err != nil {
+		t.Fatalf("%s: ancestors(v2): %s", desc, err)
+	}
+	decs, err := r.Decorations()
+	if err != nil {
+		t.Fatalf("%s: decorations: %s", desc, err)
+	}
+	var ups []string
+	for _, d := range decs {
+		if d.Hash == v2 {
+			continue
+		}
+		up, err := r.IsAncestor(v2, v2anc, d.Hash)
+		if err != nil {
+			t.Fatalf("%s: isancestor: %s", desc, err)
+		}
+		if up {
+			ups = append(ups, d.Names...)
+		}
+	}
+	sort.Strings(ups)
+	if want := []string{"master", "side", "side-1", "stable", "v3"}; !reflect.DeepEqual(ups, want) {
+		t.Errorf("%s: updates from v2 = %q, want %q", desc, ups, want)
+	}
+}
+
+func TestLoose(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	checkRepo(t, "loose", dir)
+}
+
+func TestPacked(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	git(t, dir, "gc", "-q", "--aggressive")
+	if _, err := os.Stat(filepath.Join(dir, ".git", "packed-refs")); err != nil {
+		t.Fatalf("gc did not pack refs: %s", err)
+	}
+	checkRepo(t, "packed", dir)
+}
+
+func TestWorktree(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	wt := dir + "-wt"
+	defer os.RemoveAll(wt)
+	git(t, dir, "worktree", "add", "-q", "--detach", wt, "v1")
+
+	r, err := Open(wt)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer r.Close()
+	if r.Root != wt {
+		t.Errorf("root = %q, want %q", r.Root, wt)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("head: %s", err)
+	}
+	if got, want := head.String(), git(t, dir, "rev-parse", "v1"); got != want {
+		t.Errorf("head = %s, want %s", got, want)
+	}
+	if ref, err := r.HeadRef(); err != nil || ref != "" {
+		t.Errorf("headref = %q, %v; want detached", ref, err)
+	}
+}
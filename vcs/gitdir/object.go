@@ -0,0 +1,130 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitdir
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Object types, as numbered in packfiles.
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var typeNames = map[string]int{
+	"commit": objCommit,
+	"tree":   objTree,
+	"blob":   objBlob,
+	"tag":    objTag,
+}
+
+// An objdir is a directory containing loose objects and packfiles.
+type objdir struct {
+	dir   string
+	packs []*pack
+}
+
+func openObjdir(dir string) (*objdir, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("gitdir: %s", err)
+	}
+	od := &objdir{dir: dir}
+	idxs, err := filepath.Glob(filepath.Join(dir, "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		p, err := openPack(idx)
+		if err != nil {
+			od.close()
+			return nil, err
+		}
+		od.packs = append(od.packs, p)
+	}
+	return od, nil
+}
+
+func (od *objdir) close() {
+	for _, p := range od.packs {
+		p.close()
+	}
+	od.packs = nil
+}
+
+// readLoose reads a loose object, returning os.ErrNotExist if it isn't there.
+func (od *objdir) readLoose(h Hash) (int, []byte, error) {
+	name := h.String()
+	f, err := os.Open(filepath.Join(od.dir, name[:2], name[2:]))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("gitdir: object %s: %s", h, err)
+	}
+	defer z.Close()
+	raw, err := ioutil.ReadAll(z)
+	if err != nil {
+		return 0, nil, fmt.Errorf("gitdir: object %s: %s", h, err)
+	}
+
+	// The header is "<type> <size>\x00"
+	nul := bytes.IndexByte(raw, 0)
+	sp := bytes.IndexByte(raw, ' ')
+	if nul < 0 || sp < 0 || sp > nul {
+		return 0, nil, fmt.Errorf("gitdir: object %s: malformed header", h)
+	}
+	kind, ok := typeNames[string(raw[:sp])]
+	if !ok {
+		return 0, nil, fmt.Errorf("gitdir: object %s: unknown type %q", h, raw[:sp])
+	}
+	size, err := strconv.Atoi(string(raw[sp+1 : nul]))
+	if err != nil || size != len(raw)-nul-1 {
+		return 0, nil, fmt.Errorf("gitdir: object %s: bad size", h)
+	}
+	return kind, raw[nul+1:], nil
+}
+
+// object returns the type and contents of the named object.
+func (r *Repo) object(h Hash) (int, []byte, error) {
+	for _, od := range r.objdirs {
+		for _, p := range od.packs {
+			if off, ok := p.find(h); ok {
+				return p.read(r, off, 0)
+			}
+		}
+	}
+	for _, od := range r.objdirs {
+		kind, data, err := od.readLoose(h)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return kind, data, err
+	}
+	return 0, nil, fmt.Errorf("gitdir: object %s not found", h)
+}
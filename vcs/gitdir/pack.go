@@ -0,0 +1,267 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitdir
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// maxDeltaDepth bounds the length of delta chains that will be followed.
+const maxDeltaDepth = 100
+
+// A pack is an open packfile along with its (version 2) index.
+type pack struct {
+	name    string
+	file    *os.File
+	fanout  [256]uint32
+	names   []byte // sorted object names, 20 bytes each
+	offsets []byte // 4-byte offsets; MSB set means index into large
+	large   []byte // 8-byte offsets
+}
+
+var idxMagic = []byte{0377, 't', 'O', 'c'}
+
+func openPack(idxFile string) (*pack, error) {
+	idx, err := ioutil.ReadFile(idxFile)
+	if err != nil {
+		return nil, fmt.Errorf("gitdir: %s", err)
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:4], idxMagic) {
+		return nil, fmt.Errorf("gitdir: %s: unsupported pack index version", idxFile)
+	}
+	if v := binary.BigEndian.Uint32(idx[4:]); v != 2 {
+		return nil, fmt.Errorf("gitdir: %s: unsupported pack index version %d", idxFile, v)
+	}
+
+	p := &pack{name: strings.TrimSuffix(idxFile, ".idx") + ".pack"}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(idx[8+4*i:])
+	}
+	n := int(p.fanout[255])
+	rest := idx[8+256*4:]
+	if len(rest) < n*(20+4+4) {
+		return nil, fmt.Errorf("gitdir: %s: truncated", idxFile)
+	}
+	p.names, rest = rest[:20*n], rest[20*n:]
+	rest = rest[4*n:] // CRCs
+	p.offsets, rest = rest[:4*n], rest[4*n:]
+	p.large = rest
+
+	if p.file, err = os.Open(p.name); err != nil {
+		return nil, fmt.Errorf("gitdir: %s", err)
+	}
+	return p, nil
+}
+
+func (p *pack) close() {
+	p.file.Close()
+}
+
+// find returns the offset of the named object within the pack.
+func (p *pack) find(h Hash) (int64, bool) {
+	lo := 0
+	if h[0] > 0 {
+		lo = int(p.fanout[h[0]-1])
+	}
+	hi := int(p.fanout[h[0]])
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch bytes.Compare(p.names[20*mid:20*mid+20], h[:]) {
+		case 0:
+			return p.offset(mid), true
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+func (p *pack) offset(i int) int64 {
+	off := binary.BigEndian.Uint32(p.offsets[4*i:])
+	if off&0x80000000 == 0 {
+		return int64(off)
+	}
+	li := int(off &^ 0x80000000)
+	if 8*li+8 > len(p.large) {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint64(p.large[8*li:]))
+}
+
+// read returns the type and contents of the object at the given offset,
+// resolving deltas as necessary.
+func (p *pack) read(r *Repo, off int64, depth int) (int, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, fmt.Errorf("gitdir: %s: delta chain too long", p.name)
+	}
+	if off < 0 {
+		return 0, nil, fmt.Errorf("gitdir: %s: bad offset", p.name)
+	}
+	br := bufio.NewReader(io.NewSectionReader(p.file, off, 1<<62))
+
+	// The header is a type and a variable-length size
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+	}
+	kind := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var base []byte
+	var baseKind int
+	switch kind {
+	case objCommit, objTree, objBlob, objTag:
+	case objOfsDelta:
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		if baseKind, base, err = p.read(r, off-rel, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case objRefDelta:
+		var h Hash
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+		}
+		if baseOff, ok := p.find(h); ok {
+			baseKind, base, err = p.read(r, baseOff, depth+1)
+		} else {
+			baseKind, base, err = r.object(h)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("gitdir: %s: unknown object type %d", p.name, kind)
+	}
+
+	z, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+	}
+	defer z.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(z, data); err != nil {
+		return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+	}
+
+	if base == nil {
+		return kind, data, nil
+	}
+	out, err := applyDelta(base, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("gitdir: %s: %s", p.name, err)
+	}
+	return baseKind, out, nil
+}
+
+// applyDelta reconstructs an object from its base and a git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() (int, error) {
+		var n int
+		for shift := uint(0); ; shift += 7 {
+			if len(delta) == 0 {
+				return 0, fmt.Errorf("truncated delta")
+			}
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return n, nil
+			}
+		}
+	}
+	srcSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base size %d, want %d", len(base), srcSize)
+	}
+	dstSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// Copy from base; the low bits say which offset/size bytes follow
+			var off, n int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("truncated delta")
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > len(base) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			// Insert literal data
+			n := int(op)
+			if n > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+		default:
+			return nil, fmt.Errorf("reserved delta opcode")
+		}
+	}
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("delta produced %d bytes, want %d", len(out), dstSize)
+	}
+	return out, nil
+}
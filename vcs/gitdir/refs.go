@@ -0,0 +1,209 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitdir
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymrefDepth is the number of symbolic refs that will be followed.
+const maxSymrefDepth = 5
+
+// Head returns the commit currently checked out.
+func (r *Repo) Head() (Hash, error) {
+	return r.Resolve("HEAD")
+}
+
+// HeadRef returns the name of the ref HEAD points to (e.g. "refs/heads/master"),
+// or "" if HEAD is detached.
+func (r *Repo) HeadRef() (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.GitDir, "HEAD"))
+	if err != nil {
+		return "", fmt.Errorf("gitdir: %s", err)
+	}
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, "ref:") {
+		return "", nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "ref:")), nil
+}
+
+// Resolve returns the object named by rev, which may be a full object name,
+// "HEAD", a full ref name, or the short name of a tag, branch or remote branch.
+// Tags are not peeled.
+func (r *Repo) Resolve(rev string) (Hash, error) {
+	if h, err := ParseHash(rev); err == nil {
+		return h, nil
+	}
+	if rev == "HEAD" {
+		return r.readRef(r.GitDir, "HEAD", 0)
+	}
+	refs, err := r.Refs()
+	if err != nil {
+		return Hash{}, err
+	}
+	for _, name := range []string{
+		rev,
+		"refs/" + rev,
+		"refs/tags/" + rev,
+		"refs/heads/" + rev,
+		"refs/remotes/" + rev,
+		"refs/remotes/" + rev + "/HEAD",
+	} {
+		if h, ok := refs[name]; ok {
+			return h, nil
+		}
+	}
+	return Hash{}, fmt.Errorf("gitdir: unknown revision %q", rev)
+}
+
+// Refs returns the objects named by every ref in the repository, indexed by
+// full ref name.  Symbolic refs are resolved and tags are not peeled.
+func (r *Repo) Refs() (map[string]Hash, error) {
+	if r.refs != nil {
+		return r.refs, nil
+	}
+	refs := map[string]Hash{}
+
+	// Packed refs are overridden by loose refs
+	packed, err := r.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name, h := range packed {
+		refs[name] = h
+	}
+
+	base := filepath.Join(r.Common, "refs")
+	err = filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Common, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		h, err := r.readRef(r.Common, name, 0)
+		if err != nil {
+			// Dangling symbolic refs are ignored, just like git does
+			return nil
+		}
+		refs[name] = h
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gitdir: reading refs: %s", err)
+	}
+	r.refs = refs
+	return refs, nil
+}
+
+// readRef reads the named ref from the loose ref file in dir, following
+// symbolic refs and falling back to packed refs.
+func (r *Repo) readRef(dir, name string, depth int) (Hash, error) {
+	if depth > maxSymrefDepth {
+		return Hash{}, fmt.Errorf("gitdir: symbolic ref loop at %q", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		packed, err := r.packedRefs()
+		if err != nil {
+			return Hash{}, err
+		}
+		if h, ok := packed[name]; ok {
+			return h, nil
+		}
+		return Hash{}, fmt.Errorf("gitdir: unknown ref %q", name)
+	} else if err != nil {
+		return Hash{}, fmt.Errorf("gitdir: %s", err)
+	}
+
+	line := strings.TrimSpace(string(b))
+	if strings.HasPrefix(line, "ref:") {
+		// Symbolic refs always point into the common directory
+		target := strings.TrimSpace(strings.TrimPrefix(line, "ref:"))
+		return r.readRef(r.Common, target, depth+1)
+	}
+	return ParseHash(line)
+}
+
+// packedRefs parses the packed-refs file.
+func (r *Repo) packedRefs() (map[string]Hash, error) {
+	refs := map[string]Hash{}
+	f, err := os.Open(filepath.Join(r.Common, "packed-refs"))
+	if os.IsNotExist(err) {
+		return refs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("gitdir: %s", err)
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		// Comments (like the header) and peeled tags (^hash) are skipped
+		if len(line) == 0 || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("gitdir: malformed packed-refs line %q", line)
+		}
+		h, err := ParseHash(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		refs[fields[1]] = h
+	}
+	if err := scan.Err(); err != nil {
+		return nil, fmt.Errorf("gitdir: reading packed-refs: %s", err)
+	}
+	return refs, nil
+}
+
+// decorated lists the ref namespaces which are used to decorate commits, in
+// the order in which they are listed.
+var decorated = []string{"refs/tags/", "refs/heads/", "refs/remotes/"}
+
+// ShortName returns the abbreviated name git uses when decorating commits
+// with the given ref (e.g. "v1.0" for "refs/tags/v1.0").  The second return
+// value is false for refs which are not tags, branches or remote branches.
+func ShortName(ref string) (string, bool) {
+	_, name := refRank(ref)
+	return name, name != ref
+}
+
+// refRank returns the index of the ref's namespace within decorated (or
+// len(decorated)) along with its short name.
+func refRank(ref string) (int, string) {
+	for i, prefix := range decorated {
+		if strings.HasPrefix(ref, prefix) {
+			return i, strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return len(decorated), ref
+}
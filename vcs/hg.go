@@ -0,0 +1,157 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hgDriver is the Driver for Mercurial.
+type hgDriver struct{}
+
+func (hgDriver) Name() string { return "hg" }
+
+func (hgDriver) Root(dir string) (string, error) {
+	// This is equivalent to "hg root" without the cost of starting python.
+	return findMarker(dir, ".hg")
+}
+
+func (hgDriver) Head(root string) (string, error) {
+	out, err := run(root, "hg", "log", "--template={node}", "--rev=.")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (hgDriver) Checkout(root, rev string) error {
+	_, err := run(root, "hg", "update", rev)
+	return err
+}
+
+// hgLogRegex parses the output of hg log --template="{node} {tags}\n"
+var hgLogRegex = regexp.MustCompile(`^([a-z0-9]+) (.*)`)
+
+func (hgDriver) Tags(root string) ([]Tag, error) {
+	out, err := run(root, "hg", "log", "--template={node} {tags}\n",
+		"--rev=reverse(ancestors(.)) and branch(.) and tag()")
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(out, hgLogRegex), nil
+}
+
+func (hgDriver) Updates(root string) ([]Tag, error) {
+	out, err := run(root, "hg", "log", "--template={node} {tags}\n",
+		"--rev=reverse(descendants(.)) and branch(.) and tag() and not .")
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(out, hgLogRegex), nil
+}
+
+func (hgDriver) Dirty(root string) (bool, error) {
+	out, err := run(root, "hg", "status", "--modified", "--added", "--removed", "--deleted")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// Mercurial working copies always follow the named branch of the revision
+// they are at, so only bookmarks are treated as branches.
+
+func (hgDriver) Branch(root string) (string, error) {
+	out, err := run(root, "hg", "log", "--template={activebookmark}", "--rev=.")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (hgDriver) BranchRev(root, branch string) (string, error) {
+	out, err := run(root, "hg", "log", "--template={node}", "--rev=bookmark("+hgQuote(branch)+")")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (hgDriver) SetBranch(root, branch, rev string) error {
+	if _, err := run(root, "hg", "bookmark", "--force", "--rev", rev, branch); err != nil {
+		return err
+	}
+	// Updating to a bookmark by name makes it active
+	_, err := run(root, "hg", "update", branch)
+	return err
+}
+
+// hgQuote quotes s as a string for use in a revset.
+func hgQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// hgShelve is the name of the shelf used for stashed changes.  The shelve
+// extension ships with Mercurial but is not enabled by default.
+const hgShelve = "rx-autostash"
+
+func (hgDriver) Stash(root string) error {
+	_, err := run(root, "hg", "--config", "extensions.shelve=", "shelve", "--quiet", "--name", hgShelve)
+	return err
+}
+
+func (hgDriver) Unstash(root string) error {
+	_, err := run(root, "hg", "--config", "extensions.shelve=", "unshelve", "--quiet", "--name", hgShelve)
+	return err
+}
+
+func (hgDriver) Count(root, from, to string) (int, error) {
+	out, err := run(root, "hg", "log", "--template=.",
+		"--rev=only("+hgQuote(to)+", "+hgQuote(from)+")")
+	if err != nil {
+		return 0, err
+	}
+	return len(strings.TrimSpace(out)), nil
+}
+
+func (hgDriver) TagsBetween(root, from, to string) ([]Tag, error) {
+	out, err := run(root, "hg", "log", "--template={node} {tags}\n",
+		"--rev=reverse(only("+hgQuote(to)+", "+hgQuote(from)+")) and tag()")
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, tag := range parseTags(out, hgLogRegex) {
+		// tip is a moving pseudo-tag, not a real one
+		if tag.Name != "tip" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (hgDriver) HasRev(root, rev string) (bool, error) {
+	out, err := run(root, "hg", "log", "--template={node}", "--rev=present("+hgQuote(rev)+")")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (hgDriver) Fetch(root string) error {
+	_, err := run(root, "hg", "pull")
+	return err
+}
@@ -0,0 +1,235 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// svnDriver is the Driver for Subversion.
+//
+// Tags follow the usual convention of copies into a tags/ directory which is
+// a sibling of trunk/ and branches/, and the revision of a tag is the revision
+// from which it was copied.
+type svnDriver struct{}
+
+func (svnDriver) Name() string { return "svn" }
+
+func (svnDriver) Root(dir string) (string, error) {
+	root, err := findMarker(dir, ".svn")
+	if err != nil {
+		return "", err
+	}
+	// Working copies from before 1.7 have a .svn in every directory
+	for {
+		parent := filepath.Dir(root)
+		if parent == root {
+			return root, nil
+		}
+		if _, err := os.Stat(filepath.Join(parent, ".svn")); err != nil {
+			return root, nil
+		}
+		root = parent
+	}
+}
+
+// info returns the fields from "svn info" for the working copy.
+func (svnDriver) info(root string) (map[string]string, error) {
+	out, err := run(root, "svn", "info", "--non-interactive")
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			info[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return info, nil
+}
+
+func (d svnDriver) Head(root string) (string, error) {
+	info, err := d.info(root)
+	if err != nil {
+		return "", err
+	}
+	rev, ok := info["Revision"]
+	if !ok {
+		return "", fmt.Errorf("svn: no revision in svn info")
+	}
+	return rev, nil
+}
+
+func (d svnDriver) Checkout(root, rev string) error {
+	if _, err := strconv.Atoi(rev); err != nil {
+		// Tags are checked out by updating to the revision they were copied from
+		tags, err := d.allTags(root)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, t := range tags {
+			if t.Name == rev {
+				rev, found = t.Rev, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("svn: unknown revision or tag %q", rev)
+		}
+	}
+	_, err := run(root, "svn", "update", "--non-interactive", "-r", rev)
+	return err
+}
+
+func (d svnDriver) Tags(root string) ([]Tag, error) {
+	_, down, err := d.tags(root)
+	return down, err
+}
+
+func (d svnDriver) Updates(root string) ([]Tag, error) {
+	up, _, err := d.tags(root)
+	return up, err
+}
+
+// tags splits the tags into those copied from revisions after the working
+// copy's revision (up) and those at or before it (down).
+func (d svnDriver) tags(root string) (up, down []Tag, err error) {
+	head, err := d.Head(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := strconv.Atoi(head)
+	if err != nil {
+		return nil, nil, fmt.Errorf("svn: unexpected revision %q", head)
+	}
+	all, err := d.allTags(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range all {
+		if t.revno > current {
+			up = append(up, t.Tag)
+		} else {
+			down = append(down, t.Tag)
+		}
+	}
+	return up, down, nil
+}
+
+// svnLog is the XML output of "svn log --xml -v".
+type svnLog struct {
+	Entries []struct {
+		Revision int `xml:"revision,attr"`
+		Paths    []struct {
+			Action       string `xml:"action,attr"`
+			CopyFromPath string `xml:"copyfrom-path,attr"`
+			CopyFromRev  int    `xml:"copyfrom-rev,attr"`
+			Path         string `xml:",chardata"`
+		} `xml:"paths>path"`
+	} `xml:"logentry"`
+}
+
+// allTags lists the tags in the project's tags/ directory, newest first.
+func (d svnDriver) allTags(root string) ([]revnoTag, error) {
+	info, err := d.info(root)
+	if err != nil {
+		return nil, err
+	}
+	wcURL, repoRoot := info["URL"], info["Repository Root"]
+
+	// Find the project directory containing trunk, branches and tags
+	base := ""
+	for _, marker := range []string{"/trunk/", "/branches/", "/tags/"} {
+		if i := strings.LastIndex(wcURL+"/", marker); i >= 0 {
+			base = wcURL[:i]
+			break
+		}
+	}
+	if base == "" {
+		return nil, fmt.Errorf("svn: %q is not in a trunk, branches or tags directory", wcURL)
+	}
+	tagsURL := base + "/tags"
+	if !strings.HasPrefix(tagsURL, repoRoot) {
+		return nil, fmt.Errorf("svn: %q is not within %q", tagsURL, repoRoot)
+	}
+	tagsPath, err := url.PathUnescape(strings.TrimPrefix(tagsURL, repoRoot))
+	if err != nil {
+		return nil, fmt.Errorf("svn: %s", err)
+	}
+
+	out, err := run(root, "svn", "log", "--non-interactive", "--xml", "-v", "-q", tagsURL)
+	if err != nil {
+		return nil, err
+	}
+	var log svnLog
+	if err := xml.Unmarshal([]byte(out), &log); err != nil {
+		return nil, fmt.Errorf("svn: parsing log: %s", err)
+	}
+
+	// Replay the history of the tags directory, oldest first
+	revs := map[string]int{}
+	for i := len(log.Entries) - 1; i >= 0; i-- {
+		for _, p := range log.Entries[i].Paths {
+			if path.Dir(p.Path) != tagsPath {
+				continue
+			}
+			name := path.Base(p.Path)
+			switch {
+			case p.Action == "D":
+				delete(revs, name)
+			case p.CopyFromPath != "":
+				revs[name] = p.CopyFromRev
+			}
+		}
+	}
+
+	tags := make([]revnoTag, 0, len(revs))
+	for name, rev := range revs {
+		tags = append(tags, revnoTag{Tag{name, strconv.Itoa(rev)}, rev})
+	}
+	sort.Sort(byName(tags))
+	sort.Stable(byRevno(tags))
+	return tags, nil
+}
+
+func (svnDriver) Dirty(root string) (bool, error) {
+	out, err := run(root, "svn", "status", "--non-interactive", "-q")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (svnDriver) Fetch(root string) error {
+	// Subversion has no local history; tags are always queried from the server.
+	return nil
+}
+
+// byName sorts tags by name.
+type byName []revnoTag
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
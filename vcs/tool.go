@@ -0,0 +1,291 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// A Tool describes a simple version control system entirely by the commands
+// used to perform each operation.  Use NewToolDriver to make a Driver from it.
+type Tool struct {
+	// General tool-specific settings
+	Command string
+	HeadRev string
+
+	// This command lists the repository root and should fail outside a repository.
+	RootDir []string
+
+	// This command updates to the given revision
+	ToRev []string // {{.}} == revision
+
+	// This command returns an absolute commit identifier for the current HEAD.
+	Current []string
+
+	// This command pulls new revisions and tags from the default remote
+	// without changing the working copy.
+	Fetch []string
+
+	// This command lists changes to tracked files in the working copy.  Any
+	// output indicates that the working copy is dirty.
+	Status []string
+
+	// These commands set aside uncommitted changes and reapply them.
+	Stash   []string
+	Unstash []string
+
+	// This command and regex are used to parse commit IDs and tags.
+	// The command should produce commits in reverse chronological order.
+	// Only ancestors of the given revision should be listed.
+	// The regex should leave the commit ID in $1 and a comma/whitespace
+	// separated list of tags in $2.
+	TagList      []string // {{.}} == revision
+	TagListRegex string
+
+	// This command is identical to TagList except it lists tags
+	// for which the given revision is an ancestor.
+	Updates      []string // {{.}} == revision
+	UpdatesRegex string
+}
+
+// A toolDriver is a Driver which runs the commands described by a Tool.
+type toolDriver struct {
+	name string
+	tool *Tool
+
+	tagList *regexp.Regexp
+	updates *regexp.Regexp
+}
+
+// NewToolDriver returns a Driver with the given name which uses the commands
+// in tool.  An error is returned if the tool is missing a required command or
+// if any of its templates or regular expressions are invalid.
+func NewToolDriver(name string, tool *Tool) (Driver, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n/") {
+		return nil, fmt.Errorf("vcs: invalid tool name %q", name)
+	}
+	if tool == nil {
+		return nil, fmt.Errorf("vcs: %s: no tool definition", name)
+	}
+	if tool.Command == "" {
+		return nil, fmt.Errorf("vcs: %s: no Command", name)
+	}
+
+	commands := []struct {
+		field    string
+		args     []string
+		required bool
+	}{
+		{"RootDir", tool.RootDir, true},
+		{"ToRev", tool.ToRev, true},
+		{"Current", tool.Current, true},
+		{"Fetch", tool.Fetch, false},
+		{"Status", tool.Status, false},
+		{"Stash", tool.Stash, false},
+		{"Unstash", tool.Unstash, false},
+		{"TagList", tool.TagList, false},
+		{"Updates", tool.Updates, false},
+	}
+	for _, c := range commands {
+		if c.required && len(c.args) == 0 {
+			return nil, fmt.Errorf("vcs: %s: no %s command", name, c.field)
+		}
+		// Every argument is given a revision (or "") when it is run, so
+		// try it with one now rather than failing in the middle of a scan.
+		for _, arg := range c.args {
+			if _, err := tsub(arg, "rev"); err != nil {
+				return nil, fmt.Errorf("vcs: %s: %s: %s", name, c.field, err)
+			}
+		}
+	}
+
+	d := &toolDriver{name: name, tool: tool}
+	regexes := []struct {
+		field   string
+		expr    string
+		command []string
+		re      **regexp.Regexp
+	}{
+		{"TagListRegex", tool.TagListRegex, tool.TagList, &d.tagList},
+		{"UpdatesRegex", tool.UpdatesRegex, tool.Updates, &d.updates},
+	}
+	for _, r := range regexes {
+		re, err := regexp.Compile(r.expr)
+		if err != nil {
+			return nil, fmt.Errorf("vcs: %s: %s: %s", name, r.field, err)
+		}
+		if len(r.command) > 0 && re.NumSubexp() < 2 {
+			return nil, fmt.Errorf("vcs: %s: %s must have two groups (commit and tags)", name, r.field)
+		}
+		*r.re = re
+	}
+	return d, nil
+}
+
+// ReadTools reads Tool definitions from JSON of the form
+//
+//	{"name": {"Command": "tool", "RootDir": ["root"], ...}, ...}
+//
+// and returns a validated Driver for each, ordered by name.
+func ReadTools(r io.Reader) ([]Driver, error) {
+	var tools map[string]*Tool
+	if err := json.NewDecoder(r).Decode(&tools); err != nil {
+		return nil, fmt.Errorf("vcs: decoding tools: %s", err)
+	}
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []Driver
+	for _, name := range names {
+		if tools[name] == nil {
+			return nil, fmt.Errorf("vcs: %s: tool definition is null", name)
+		}
+		d, err := NewToolDriver(name, tools[name])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+func (d *toolDriver) Name() string { return d.name }
+
+// run runs the given command, substituting arg for {{.}} in its arguments.
+func (d *toolDriver) run(dir string, command []string, arg string) (string, error) {
+	if len(command) == 0 {
+		return "", ErrUnsupported
+	}
+	args := make([]string, 0, len(command))
+	for _, a := range command {
+		sub, err := tsub(a, arg)
+		if err != nil {
+			return "", fmt.Errorf("vcs: %s: %s", d.name, err)
+		}
+		args = append(args, sub)
+	}
+	return run(dir, d.tool.Command, args...)
+}
+
+func (d *toolDriver) Root(dir string) (string, error) {
+	out, err := d.run(dir, d.tool.RootDir, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (d *toolDriver) Head(root string) (string, error) {
+	out, err := d.run(root, d.tool.Current, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (d *toolDriver) Checkout(root, rev string) error {
+	_, err := d.run(root, d.tool.ToRev, rev)
+	return err
+}
+
+func (d *toolDriver) Tags(root string) ([]Tag, error) {
+	return d.revTags(root, d.tool.TagList, d.tagList)
+}
+
+func (d *toolDriver) Updates(root string) ([]Tag, error) {
+	return d.revTags(root, d.tool.Updates, d.updates)
+}
+
+func (d *toolDriver) Dirty(root string) (bool, error) {
+	out, err := d.run(root, d.tool.Status, "")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (d *toolDriver) Stash(root string) error {
+	_, err := d.run(root, d.tool.Stash, "")
+	return err
+}
+
+func (d *toolDriver) Unstash(root string) error {
+	_, err := d.run(root, d.tool.Unstash, "")
+	return err
+}
+
+func (d *toolDriver) Fetch(root string) error {
+	_, err := d.run(root, d.tool.Fetch, "")
+	return err
+}
+
+func (d *toolDriver) revTags(root string, command []string, reg *regexp.Regexp) ([]Tag, error) {
+	out, err := d.run(root, command, d.tool.HeadRev)
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(out, reg), nil
+}
+
+var tagWord = regexp.MustCompile(`[^, ]+`)
+
+// parseTags parses the output of a tag listing command, in which each line
+// matching reg has a commit ID in $1 and a list of tags in $2.
+func parseTags(out string, reg *regexp.Regexp) []Tag {
+	var tags []Tag
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		match := reg.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		for _, tagName := range tagWord.FindAllString(match[2], -1) {
+			// Newer versions of git decorate refs as "tag: name" and "HEAD -> branch"
+			if tagName == "tag:" || tagName == "->" {
+				continue
+			}
+			tags = append(tags, Tag{
+				Name: tagName,
+				Rev:  match[1],
+			})
+		}
+	}
+	return tags
+}
+
+// tsub executes the template tpl with the given data.
+func tsub(tpl string, data interface{}) (string, error) {
+	t, err := template.New("arg").Parse(tpl)
+	if err != nil {
+		return "", err
+	}
+	b := new(bytes.Buffer)
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
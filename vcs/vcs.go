@@ -12,61 +12,188 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package vcs provides a uniform interface to version control systems.
 package vcs
 
-type Tool struct {
-	// General tool-specific settings
-	Command string
-	HeadRev string
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
 
-	// This command lists the repository root and should fail outside a repository.
-	RootDir []string
+// ErrUnsupported is returned by a Driver for an operation which its version
+// control system (or its tool definition) cannot perform.
+var ErrUnsupported = errors.New("vcs: operation not supported")
 
-	// This command updates to the given revision
-	ToRev []string // {{.}} == revision
+// A Driver performs operations on the repositories of one version control
+// system.  Every method other than Name and Root takes the root directory of
+// a repository, as returned by Root.
+type Driver interface {
+	// Name returns the short name of the version control system (e.g. "git").
+	Name() string
 
-	// This command returns an absolute commit identifier for the current HEAD.
-	Current []string
+	// Root returns the root directory of the repository containing dir, and
+	// returns an error if dir is not within such a repository.
+	Root(dir string) (string, error)
 
-	// This command and regex are used to parse commit IDs and tags.
-	// The command should produce commits in reverse chronological order.
-	// Only ancestors of the given revision should be listed.
-	// The regex should leave the commit ID in $1 and a comma/whitespace
-	// separated list of tags in $2.
-	TagList      []string // {{.}} == revision
-	TagListRegex string
+	// Head returns an absolute identifier for the current revision.
+	Head(root string) (string, error)
 
-	// This command is identical to TagList except it lists tags
-	// for which the given revision is an ancestor.
-	Updates      []string // {{.}} == revision
-	UpdatesRegex string
+	// Checkout updates the working copy to the given revision.
+	Checkout(root, rev string) error
+
+	// Tags returns the tags which are ancestors of the current revision
+	// (including the current revision itself), newest first.
+	Tags(root string) ([]Tag, error)
+
+	// Updates returns the tags for which the current revision is an ancestor,
+	// newest first.
+	Updates(root string) ([]Tag, error)
+
+	// Dirty returns true if the working copy has uncommitted changes to
+	// tracked files.
+	Dirty(root string) (bool, error)
+
+	// Fetch pulls new revisions and tags from the default remote without
+	// changing the working copy, or returns ErrUnsupported if that isn't
+	// possible.
+	Fetch(root string) error
+}
+
+// A Stasher is a Driver which can temporarily set aside uncommitted changes.
+type Stasher interface {
+	// Stash sets aside uncommitted changes to tracked files, leaving the
+	// working copy clean.
+	Stash(root string) error
+
+	// Unstash reapplies the changes most recently set aside by Stash.
+	Unstash(root string) error
+}
+
+// A Brancher is a Driver whose working copy can be attached to a movable,
+// named branch (such as a git branch or a Mercurial bookmark) rather than to a
+// fixed revision.
+type Brancher interface {
+	// Branch returns the name of the branch the working copy is attached
+	// to, or "" if it is not attached to one.
+	Branch(root string) (string, error)
+
+	// BranchRev returns the revision the named branch currently points to.
+	BranchRev(root, branch string) (string, error)
+
+	// SetBranch points the named branch at rev, creating it if necessary,
+	// and attaches the working copy to it.
+	SetBranch(root, branch, rev string) error
+}
+
+// A Counter is a Driver which can count the commits between two revisions.
+type Counter interface {
+	// Count returns the number of commits which are ancestors of (or are)
+	// rev to, but are not ancestors of (and are not) rev from.  Both
+	// revisions must exist in the repository.
+	Count(root, from, to string) (int, error)
+}
+
+// A TagLister is a Driver which can list the tags between two revisions.
+type TagLister interface {
+	// TagsBetween returns the tags (but not branches) on commits which
+	// are ancestors of (or are) rev to, but are not ancestors of (and are
+	// not) rev from, newest first.
+	TagsBetween(root, from, to string) ([]Tag, error)
+}
+
+// A RevChecker is a Driver which can check whether a revision is present in
+// the repository without changing it.
+type RevChecker interface {
+	// HasRev returns true if rev names a revision in the repository.
+	HasRev(root, rev string) (bool, error)
+}
+
+// A Tag is a named revision.
+type Tag struct {
+	Name string
+	Rev  string
+}
+
+var drivers = map[string]Driver{}
+
+// Register makes a driver available by its name, replacing any driver
+// previously registered with the same name.
+func Register(d Driver) {
+	drivers[d.Name()] = d
+}
+
+// Unregister removes the driver registered with the given name, if any.
+func Unregister(name string) {
+	delete(drivers, name)
+}
+
+// Lookup returns the driver registered with the given name.
+func Lookup(name string) (Driver, error) {
+	d, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("vcs: unknown vcs %q", name)
+	}
+	return d, nil
+}
+
+// Drivers returns all registered drivers, ordered by name.
+func Drivers() []Driver {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]Driver, 0, len(names))
+	for _, name := range names {
+		list = append(list, drivers[name])
+	}
+	return list
+}
+
+// run runs the command in dir and returns its standard output, with any
+// standard error included in the returned error.
+func run(dir, command string, args ...string) (string, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("%s %s: %s: %s", command, strings.Join(args, " "), err,
+				strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("%s %s: %s", command, strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// findMarker returns the closest ancestor of dir (or dir itself) which
+// contains the named file or directory.
+func findMarker(dir, marker string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("vcs: no %s found", marker)
+		}
+		dir = parent
+	}
 }
 
-var Known = map[string]*Tool{
-	"git": {
-		Command: "git",
-		HeadRev: "HEAD",
-		// Commands
-		RootDir: []string{"rev-parse", "--show-toplevel"},
-		ToRev:   []string{"checkout", "{{.}}"},
-		Current: []string{"log", "--pretty=format:%H", "-n", "1", "HEAD"},
-		TagList: []string{"log", "--pretty=format:%H%d", "{{.}}"},
-		Updates: []string{"log", "--pretty=format:%H%d", "--all", "^{{.}}"},
-		// Regexes
-		TagListRegex: `^([a-z0-9]+) \((.*)\)`,
-		UpdatesRegex: `^([a-z0-9]+) \((.*)\)`,
-	},
-	"hg": {
-		Command: "hg",
-		HeadRev: ".",
-		// Commands
-		RootDir: []string{"root"},
-		ToRev:   []string{"update", "{{.}}"},
-		Current: []string{"log", "--template={node}", "--rev=."},
-		TagList: []string{"log", "--template={node} {tags}\n", "--rev=reverse(ancestors({{.}}))   and branch({{.}}) and tag()"},
-		Updates: []string{"log", "--template={node} {tags}\n", "--rev=reverse(descendants({{.}})) and branch({{.}}) and tag() and not {{.}}"},
-		// Regexes
-		TagListRegex: `^([a-z0-9]+) (.*)`,
-		UpdatesRegex: `^([a-z0-9]+) (.*)`,
-	},
+func init() {
+	Register(bzrDriver{})
+	Register(gitDriver{})
+	Register(hgDriver{})
+	Register(svnDriver{})
 }
@@ -0,0 +1,499 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// command runs a command in dir, failing the test on error.
+func command(t *testing.T, dir, name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=rx", "GIT_AUTHOR_EMAIL=rx@localhost",
+		"GIT_COMMITTER_NAME=rx", "GIT_COMMITTER_EMAIL=rx@localhost",
+		"BZR_EMAIL=rx <rx@localhost>",
+		"HGUSER=rx <rx@localhost>",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s: %s\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// tempDir makes a temporary directory, skipping the test if the named
+// binary isn't available.  The caller should remove it.
+func tempDir(t *testing.T, binary string) string {
+	if _, err := exec.LookPath(binary); err != nil {
+		t.Skipf("%s not found: %s", binary, err)
+	}
+	dir, err := ioutil.TempDir("", "rx-vcs-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, contents string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatalf("write %s: %s", name, err)
+	}
+}
+
+// A history is the result of building a repository with three tagged
+// revisions, checked out at the second one.
+type history struct {
+	root string
+	revs []string // the revisions tagged v1, v2, v3
+}
+
+// testHistory checks that d reports the expected revisions and tags for h.
+func testHistory(t *testing.T, d Driver, h history) {
+	sub := filepath.Join(h.root, "sub")
+	os.Mkdir(sub, 0755)
+	if root, err := d.Root(sub); err != nil || root != h.root {
+		t.Errorf("%s: root(sub) = %q, %v; want %q", d.Name(), root, err, h.root)
+	}
+	if head, err := d.Head(h.root); err != nil || head != h.revs[1] {
+		t.Errorf("%s: head = %q, %v; want %q", d.Name(), head, err, h.revs[1])
+	}
+
+	tags, err := d.Tags(h.root)
+	if err != nil {
+		t.Fatalf("%s: tags: %s", d.Name(), err)
+	}
+	want := []Tag{{"v2", h.revs[1]}, {"v1", h.revs[0]}}
+	if got := onlyV(tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: tags = %v, want %v", d.Name(), got, want)
+	}
+
+	tags, err = d.Updates(h.root)
+	if err != nil {
+		t.Fatalf("%s: updates: %s", d.Name(), err)
+	}
+	want = []Tag{{"v3", h.revs[2]}}
+	if got := onlyV(tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: updates = %v, want %v", d.Name(), got, want)
+	}
+
+	if dirty, err := d.Dirty(h.root); err != nil || dirty {
+		t.Errorf("%s: dirty = %v, %v; want false", d.Name(), dirty, err)
+	}
+	writeFile(t, h.root, "a.txt", "local edit\n")
+	if dirty, err := d.Dirty(h.root); err != nil || !dirty {
+		t.Errorf("%s: dirty after edit = %v, %v; want true", d.Name(), dirty, err)
+	}
+}
+
+// onlyV filters out branch names and other non-version tags.
+func onlyV(tags []Tag) []Tag {
+	var vs []Tag
+	for _, tag := range tags {
+		if strings.HasPrefix(tag.Name, "v") {
+			vs = append(vs, tag)
+		}
+	}
+	return vs
+}
+
+func gitHistory(t *testing.T, dir string) history {
+	h := history{root: dir}
+	command(t, dir, "git", "init", "-q")
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		writeFile(t, dir, "a.txt", tag+"\n")
+		command(t, dir, "git", "add", "a.txt")
+		command(t, dir, "git", "commit", "-q", "-m", tag)
+		command(t, dir, "git", "tag", tag)
+		h.revs = append(h.revs, command(t, dir, "git", "rev-parse", "HEAD"))
+	}
+	if err := drivers["git"].Checkout(dir, "v2"); err != nil {
+		t.Fatalf("checkout: %s", err)
+	}
+	return h
+}
+
+func TestGit(t *testing.T) {
+	dir := tempDir(t, "git")
+	defer os.RemoveAll(dir)
+	d, err := Lookup("git")
+	if err != nil {
+		t.Fatalf("lookup: %s", err)
+	}
+	h := gitHistory(t, dir)
+	testHistory(t, d, h)
+	testStash(t, d, dir)
+	testBranch(t, d, h)
+	testCount(t, d, h)
+	testHasRev(t, d, h)
+	testTagsBetween(t, d, h)
+}
+
+func hgHistory(t *testing.T, dir string) history {
+	h := history{root: dir}
+	command(t, dir, "hg", "init", "-q")
+	for i, tag := range []string{"v1", "v2", "v3"} {
+		writeFile(t, dir, "a.txt", tag+"\n")
+		if i == 0 {
+			command(t, dir, "hg", "add", "-q", "a.txt")
+		}
+		command(t, dir, "hg", "commit", "-q", "-m", tag)
+		// Local tags don't add commits of their own, which would throw off
+		// the counts.
+		command(t, dir, "hg", "tag", "--local", tag)
+		h.revs = append(h.revs, command(t, dir, "hg", "log", "--template={node}", "--rev=."))
+	}
+	if err := drivers["hg"].Checkout(dir, "v2"); err != nil {
+		t.Fatalf("checkout: %s", err)
+	}
+	return h
+}
+
+func TestHg(t *testing.T) {
+	dir := tempDir(t, "hg")
+	defer os.RemoveAll(dir)
+	d, err := Lookup("hg")
+	if err != nil {
+		t.Fatalf("lookup: %s", err)
+	}
+	h := hgHistory(t, dir)
+	testHistory(t, d, h)
+	testStash(t, d, dir)
+	testBranch(t, d, h)
+	testCount(t, d, h)
+	testHasRev(t, d, h)
+	testTagsBetween(t, d, h)
+}
+
+// testBranch checks that d can attach the working copy of h, which must start
+// out detached at its second revision, to a branch.
+func testBranch(t *testing.T, d Driver, h history) {
+	b, ok := d.(Brancher)
+	if !ok {
+		t.Fatalf("%s: driver does not support branches", d.Name())
+	}
+	if branch, err := b.Branch(h.root); err != nil || branch != "" {
+		t.Errorf("%s: branch = %q, %v; want detached", d.Name(), branch, err)
+	}
+	if err := b.SetBranch(h.root, "work", h.revs[1]); err != nil {
+		t.Fatalf("%s: setbranch: %s", d.Name(), err)
+	}
+	if branch, err := b.Branch(h.root); err != nil || branch != "work" {
+		t.Errorf("%s: branch = %q, %v; want %q", d.Name(), branch, err, "work")
+	}
+	if rev, err := b.BranchRev(h.root, "work"); err != nil || rev != h.revs[1] {
+		t.Errorf("%s: branchrev = %q, %v; want %q", d.Name(), rev, err, h.revs[1])
+	}
+	if head, err := d.Head(h.root); err != nil || head != h.revs[1] {
+		t.Errorf("%s: head = %q, %v; want %q", d.Name(), head, err, h.revs[1])
+	}
+}
+
+// testCount checks that d counts the commits between the revisions of h.
+func testCount(t *testing.T, d Driver, h history) {
+	c, ok := d.(Counter)
+	if !ok {
+		t.Fatalf("%s: driver does not support counting commits", d.Name())
+	}
+	tests := []struct {
+		from, to int
+		want     int
+	}{
+		{0, 2, 2},
+		{1, 2, 1},
+		{2, 0, 0},
+		{1, 1, 0},
+	}
+	for _, test := range tests {
+		n, err := c.Count(h.root, h.revs[test.from], h.revs[test.to])
+		if err != nil || n != test.want {
+			t.Errorf("%s: count(v%d, v%d) = %d, %v; want %d", d.Name(), test.from+1, test.to+1, n, err, test.want)
+		}
+	}
+	if _, err := c.Count(h.root, h.revs[0], "0123456789abcdef0123456789abcdef01234567"); err == nil {
+		t.Errorf("%s: count to a missing revision succeeded", d.Name())
+	}
+}
+
+// testTagsBetween checks that d lists only the tags between revisions of h,
+// whether they are given in full or abbreviated.
+func testTagsBetween(t *testing.T, d Driver, h history) {
+	tl, ok := d.(TagLister)
+	if !ok {
+		t.Fatalf("%s: driver does not support listing tags between revisions", d.Name())
+	}
+	want := []Tag{{"v3", h.revs[2]}, {"v2", h.revs[1]}}
+	for _, from := range []string{h.revs[0], h.revs[0][:10]} {
+		tags, err := tl.TagsBetween(h.root, from, h.revs[2])
+		if err != nil {
+			t.Fatalf("%s: tagsbetween(%s): %s", d.Name(), from, err)
+		}
+		if !reflect.DeepEqual(tags, want) {
+			t.Errorf("%s: tagsbetween(%s) = %v, want %v", d.Name(), from, tags, want)
+		}
+	}
+	if tags, err := tl.TagsBetween(h.root, h.revs[2], h.revs[0]); err != nil || len(tags) > 0 {
+		t.Errorf("%s: tagsbetween(v3, v1) = %v, %v; want none", d.Name(), tags, err)
+	}
+}
+
+// testHasRev checks that d can tell which revisions are present in h.
+func testHasRev(t *testing.T, d Driver, h history) {
+	rc, ok := d.(RevChecker)
+	if !ok {
+		t.Fatalf("%s: driver does not support checking revisions", d.Name())
+	}
+	for _, rev := range append(h.revs, "v1") {
+		if has, err := rc.HasRev(h.root, rev); err != nil || !has {
+			t.Errorf("%s: hasrev(%q) = %v, %v; want true", d.Name(), rev, has, err)
+		}
+	}
+	if has, err := rc.HasRev(h.root, "0123456789abcdef0123456789abcdef01234567"); err != nil || has {
+		t.Errorf("%s: hasrev(missing) = %v, %v; want false", d.Name(), has, err)
+	}
+}
+
+// testStash checks that d can set aside the uncommitted changes in the dirty
+// working copy at root and put them back.
+func testStash(t *testing.T, d Driver, root string) {
+	s, ok := d.(Stasher)
+	if !ok {
+		t.Fatalf("%s: driver does not support stashing", d.Name())
+	}
+	if err := s.Stash(root); err != nil {
+		t.Fatalf("%s: stash: %s", d.Name(), err)
+	}
+	if dirty, err := d.Dirty(root); err != nil || dirty {
+		t.Errorf("%s: dirty after stash = %v, %v; want false", d.Name(), dirty, err)
+	}
+	if err := s.Unstash(root); err != nil {
+		t.Fatalf("%s: unstash: %s", d.Name(), err)
+	}
+	if dirty, err := d.Dirty(root); err != nil || !dirty {
+		t.Errorf("%s: dirty after unstash = %v, %v; want true", d.Name(), dirty, err)
+	}
+}
+
+func TestBzr(t *testing.T) {
+	dir := tempDir(t, "bzr")
+	defer os.RemoveAll(dir)
+	d, err := Lookup("bzr")
+	if err != nil {
+		t.Fatalf("lookup: %s", err)
+	}
+
+	h := history{root: dir}
+	command(t, dir, "bzr", "init", "-q")
+	for i, tag := range []string{"v1", "v2", "v3"} {
+		writeFile(t, dir, "a.txt", tag+"\n")
+		if i == 0 {
+			command(t, dir, "bzr", "add", "-q", "a.txt")
+		}
+		command(t, dir, "bzr", "commit", "-q", "-m", tag)
+		command(t, dir, "bzr", "tag", "-q", tag)
+		rev, err := d.Head(dir)
+		if err != nil {
+			t.Fatalf("head: %s", err)
+		}
+		h.revs = append(h.revs, rev)
+	}
+	if err := d.Checkout(dir, "v2"); err != nil {
+		t.Fatalf("checkout: %s", err)
+	}
+	testHistory(t, d, h)
+	testStash(t, d, dir)
+}
+
+func TestSvn(t *testing.T) {
+	dir := tempDir(t, "svn")
+	defer os.RemoveAll(dir)
+	if _, err := exec.LookPath("svnadmin"); err != nil {
+		t.Skipf("svnadmin not found: %s", err)
+	}
+	d, err := Lookup("svn")
+	if err != nil {
+		t.Fatalf("lookup: %s", err)
+	}
+
+	repo, wc := filepath.Join(dir, "repo"), filepath.Join(dir, "wc")
+	url := "file://" + filepath.ToSlash(repo)
+	command(t, dir, "svnadmin", "create", repo)
+	command(t, dir, "svn", "mkdir", "-q", "-m", "layout", url+"/trunk", url+"/tags")
+	command(t, dir, "svn", "checkout", "-q", url+"/trunk", wc)
+
+	h := history{root: wc}
+	for i, tag := range []string{"v1", "v2", "v3"} {
+		writeFile(t, wc, "a.txt", tag+"\n")
+		if i == 0 {
+			command(t, wc, "svn", "add", "-q", "a.txt")
+		}
+		command(t, wc, "svn", "commit", "-q", "-m", tag)
+		command(t, wc, "svn", "update", "-q")
+		rev, err := d.Head(wc)
+		if err != nil {
+			t.Fatalf("head: %s", err)
+		}
+		command(t, wc, "svn", "copy", "-q", "-m", "tag "+tag, url+"/trunk@"+rev, url+"/tags/"+tag)
+		h.revs = append(h.revs, rev)
+	}
+	if err := d.Checkout(wc, "v2"); err != nil {
+		t.Fatalf("checkout: %s", err)
+	}
+	testHistory(t, d, h)
+}
+
+func TestToolDriver(t *testing.T) {
+	dir := tempDir(t, "git")
+	defer os.RemoveAll(dir)
+
+	// The command tables used to be how git was supported
+	d, err := NewToolDriver("gittool", &Tool{
+		Command:      "git",
+		HeadRev:      "HEAD",
+		RootDir:      []string{"rev-parse", "--show-toplevel"},
+		ToRev:        []string{"checkout", "{{.}}"},
+		Current:      []string{"rev-parse", "HEAD"},
+		Status:       []string{"status", "--porcelain", "--untracked-files=no"},
+		TagList:      []string{"log", "--pretty=format:%H%d", "{{.}}"},
+		Updates:      []string{"log", "--pretty=format:%H%d", "--all", "^{{.}}"},
+		TagListRegex: `^([a-z0-9]+) \((.*)\)`,
+		UpdatesRegex: `^([a-z0-9]+) \((.*)\)`,
+	})
+	if err != nil {
+		t.Fatalf("NewToolDriver: %s", err)
+	}
+	testHistory(t, d, gitHistory(t, dir))
+
+	if err := d.Fetch(dir); err == nil {
+		t.Errorf("fetch with no Fetch command succeeded")
+	}
+}
+
+func TestReadTools(t *testing.T) {
+	tests := []struct {
+		Desc  string
+		JSON  string
+		Names []string
+		Error string
+	}{
+		{
+			Desc: "valid",
+			JSON: `{
+				"fossil": {
+					"Command": "fossil",
+					"HeadRev": "current",
+					"RootDir": ["info"],
+					"ToRev":   ["update", "{{.}}"],
+					"Current": ["info"],
+					"TagList": ["tag", "list"],
+					"TagListRegex": "^(\\S+) (.*)"
+				},
+				"git": {
+					"Command": "mygit",
+					"RootDir": ["rev-parse", "--show-toplevel"],
+					"ToRev":   ["checkout", "{{.}}"],
+					"Current": ["rev-parse", "HEAD"]
+				}
+			}`,
+			Names: []string{"fossil", "git"},
+		},
+		{
+			Desc:  "malformed",
+			JSON:  `{"x": [}`,
+			Error: "decoding",
+		},
+		{
+			Desc:  "no command",
+			JSON:  `{"x": {"RootDir": ["root"], "ToRev": ["up"], "Current": ["id"]}}`,
+			Error: "no Command",
+		},
+		{
+			Desc:  "missing required",
+			JSON:  `{"x": {"Command": "x", "RootDir": ["root"], "Current": ["id"]}}`,
+			Error: "no ToRev",
+		},
+		{
+			Desc:  "bad template",
+			JSON:  `{"x": {"Command": "x", "RootDir": ["root"], "ToRev": ["up", "{{."], "Current": ["id"]}}`,
+			Error: "ToRev",
+		},
+		{
+			Desc:  "bad template field",
+			JSON:  `{"x": {"Command": "x", "RootDir": ["{{.Rev}}"], "ToRev": ["up"], "Current": ["id"]}}`,
+			Error: "RootDir",
+		},
+		{
+			Desc:  "null tool",
+			JSON:  `{"x": null}`,
+			Error: "x: tool definition is null",
+		},
+		{
+			Desc:  "bad regex",
+			JSON:  `{"x": {"Command": "x", "RootDir": ["root"], "ToRev": ["up"], "Current": ["id"], "UpdatesRegex": "("}}`,
+			Error: "UpdatesRegex",
+		},
+		{
+			Desc:  "too few groups",
+			JSON:  `{"x": {"Command": "x", "RootDir": ["root"], "ToRev": ["up"], "Current": ["id"], "TagList": ["tags"], "TagListRegex": "(.*)"}}`,
+			Error: "two groups",
+		},
+		{
+			Desc:  "bad name",
+			JSON:  `{"my vcs": {"Command": "x", "RootDir": ["root"], "ToRev": ["up"], "Current": ["id"]}}`,
+			Error: "invalid tool name",
+		},
+	}
+
+	for _, test := range tests {
+		list, err := ReadTools(strings.NewReader(test.JSON))
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: error = %v, want %q", test.Desc, err, test.Error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.Desc, err)
+			continue
+		}
+		var names []string
+		for _, d := range list {
+			names = append(names, d.Name())
+		}
+		if !reflect.DeepEqual(names, test.Names) {
+			t.Errorf("%s: names = %q, want %q", test.Desc, names, test.Names)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if _, err := Lookup("nonexistent"); err == nil {
+		t.Errorf("lookup of unknown vcs succeeded")
+	}
+	var names []string
+	for _, d := range Drivers() {
+		names = append(names, d.Name())
+	}
+	if want := []string{"bzr", "git", "hg", "svn"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("drivers = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+var whyCmd = &Command{
+	Name:    "why",
+	Usage:   "<from> <to>",
+	Summary: "Show how one package or repository depends on another.",
+	Help: `The why command prints the shortest chain of imports through which <from>
+depends on <to>.  Each of <from> and <to> can be a package import path or a
+repository, which matches any of its packages.  As with other commands, a
+repository or package can be named by its full path, the last element(s) of its
+path, or any substring of the path as long as it is unique; repositories are
+tried before packages.
+
+With --all, every import chain is printed instead, shortest first.  A chain
+ends at the first package in <to> that it reaches and does not pass through
+the same package twice.  Use --limit to cap the number of chains printed.
+
+By default, only package imports (including those of internal tests) are
+followed.  The --edges option selects "xtest" to follow only external test
+imports, or "all" to follow both; steps which are only taken by external tests
+are marked as such.
+
+With --json, the result is printed as a JSON object with From, To, and
+Chains fields.  Each chain is a list of steps with a Package field and, for
+steps through external test imports, an XTest field.
+
+The -f option takes a template as a format.  The data passed into the
+template invocation is the same object printed by --json, and the default
+format is:
+
+` + ind2sp(whyTemplate),
+}
+
+var (
+	whyFormat = whyCmd.Flag.String("f", "", "why output format")
+	whyAll    = whyCmd.Flag.Bool("all", false, "Show all import chains, not just the shortest")
+	whyLimit  = whyCmd.Flag.Int("limit", 0, "Maximum number of chains to show with --all (0 for no limit)")
+	whyEdges  = whyCmd.Flag.String("edges", "imports", "Kinds of imports to follow (imports, xtest, or all)")
+	whyJSON   = whyCmd.Flag.Bool("json", false, "Print the result as JSON")
+)
+
+// A whyStep is one package along an import chain.
+type whyStep struct {
+	Package string
+	XTest   bool `json:",omitempty"` // Imported only by the previous package's external tests
+}
+
+type whyResult struct {
+	From, To string
+	Chains   [][]whyStep
+}
+
+func whyFunc(cmd *Command, args ...string) {
+	if len(args) != 2 {
+		cmd.BadArgs("need exactly two arguments")
+	}
+	kinds, err := graph.ParseEdgeKind(*whyEdges)
+	if err != nil {
+		cmd.BadArgs("--edges: %s", err)
+	}
+
+	fromName, from, err := whyResolve(args[0])
+	if err != nil {
+		cmd.Fatalf("<from>: %s", err)
+	}
+	toName, to, err := whyResolve(args[1])
+	if err != nil {
+		cmd.Fatalf("<to>: %s", err)
+	}
+
+	var chains []graph.ImportChain
+	if *whyAll {
+		chains = Deps.AllChains(from, to, kinds, *whyLimit)
+	} else if chain := Deps.ShortestChain(from, to, kinds); chain != nil {
+		chains = append(chains, chain)
+	}
+
+	result := whyResult{
+		From:   fromName,
+		To:     toName,
+		Chains: [][]whyStep{},
+	}
+	for _, chain := range chains {
+		steps := make([]whyStep, len(chain))
+		for i, path := range chain {
+			steps[i].Package = path
+			if i > 0 && !Deps.DependsOn[chain[i-1]][path] {
+				steps[i].XTest = true
+			}
+		}
+		result.Chains = append(result.Chains, steps)
+	}
+
+	switch {
+	case *whyJSON:
+		js, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			cmd.Fatalf("encode: %s", err)
+		}
+		fmt.Fprintf(stdout, "%s\n", js)
+	case *whyFormat != "":
+		render(stdout, *whyFormat, result)
+	default:
+		render(stdout, whyTemplate, result)
+	}
+}
+
+// whyResolve returns the name and packages of the repository or package
+// matching the given key.
+func whyResolve(key string) (name string, pkgs []string, err error) {
+	if pkg, ok := Deps.Package[key]; ok {
+		return pkg.ImportPath, []string{pkg.ImportPath}, nil
+	}
+	repo, repoErr := Deps.FindRepo(key)
+	if repoErr == nil {
+		return repo.String(), repo.Packages, nil
+	}
+	pkg, err := Deps.FindPackage(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s; %s", repoErr, err)
+	}
+	return pkg.ImportPath, []string{pkg.ImportPath}, nil
+}
+
+func init() {
+	whyCmd.Run = whyFunc
+}
+
+var whyTemplate = `{{range $i, $chain := .Chains}}{{if $i}}
+{{end}}{{range $j, $step := $chain}}{{if $j}}  imports {{end}}{{.Package}}{{if .XTest}} (external test){{end}}
+{{end}}{{else}}{{.From}} does not depend on {{.To}}
+{{end}}`
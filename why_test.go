@@ -0,0 +1,84 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"kylelemons.net/go/rx/graph"
+)
+
+func TestWhy(t *testing.T) {
+	defer func(old *graph.Graph) { Deps = old }(Deps)
+	defer func(old io.Writer) { stdout = old }(stdout)
+	Deps = testGraph(map[string][]string{
+		"example.com/app":  {"example.com/web"},
+		"example.com/web":  {"example.com/util"},
+		"example.com/util": nil,
+	})
+
+	tests := []struct {
+		Desc string
+		Args []string
+		All  bool
+		JSON bool
+		Want string
+	}{
+		{
+			Desc: "shortest",
+			Args: []string{"app", "util"},
+			Want: "example.com/app\n  imports example.com/web\n  imports example.com/util\n",
+		},
+		{
+			Desc: "none",
+			Args: []string{"util", "app"},
+			All:  true,
+			Want: "example.com/util does not depend on example.com/app\n",
+		},
+		{
+			Desc: "json",
+			Args: []string{"web", "example.com/util"},
+			JSON: true,
+			Want: `{
+  "From": "example.com/web",
+  "To": "example.com/util",
+  "Chains": [
+    [
+      {
+        "Package": "example.com/web"
+      },
+      {
+        "Package": "example.com/util"
+      }
+    ]
+  ]
+}
+`,
+		},
+	}
+
+	defer func() { *whyAll, *whyJSON = false, false }()
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		stdout = buf
+		*whyAll, *whyJSON = test.All, test.JSON
+		whyFunc(whyCmd, test.Args...)
+		if got := buf.String(); got != test.Want {
+			t.Errorf("%s: output:\n%s\nwant:\n%s", test.Desc, got, test.Want)
+		}
+	}
+}